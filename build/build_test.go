@@ -0,0 +1,248 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mockRunner records every invocation instead of executing anything, so
+// tests can assert on the command sequence without grub-mkstandalone or
+// xorriso installed.
+type mockRunner struct {
+	calls [][]string
+}
+
+func (m *mockRunner) Run(name string, args ...string) error {
+	m.calls = append(m.calls, append([]string{name}, args...))
+
+	return nil
+}
+
+func TestISOMissingToolsFailsWithClearMessage(t *testing.T) {
+	opts := ISOOptions{Stboot: "/dev/null", StbootInitramfs: "/dev/null", Out: filepath.Join(t.TempDir(), "out.iso")}
+
+	err := ISO(opts, &mockRunner{})
+	if err == nil {
+		t.Fatal("ISO() with no grub-mkstandalone/xorriso on $PATH should fail")
+	}
+
+	if !strings.Contains(err.Error(), "not found on $PATH") {
+		t.Fatalf("ISO() error = %q, want a message naming the missing tool", err)
+	}
+}
+
+func TestLayoutISOTree(t *testing.T) {
+	root := t.TempDir()
+
+	kernel := filepath.Join(root, "kernel")
+	initramfs := filepath.Join(root, "initramfs")
+
+	if err := os.WriteFile(kernel, []byte("kernel"), 0o644); err != nil {
+		t.Fatalf("write kernel: %v", err)
+	}
+
+	if err := os.WriteFile(initramfs, []byte("initramfs"), 0o644); err != nil {
+		t.Fatalf("write initramfs: %v", err)
+	}
+
+	tree := t.TempDir()
+	runner := &mockRunner{}
+
+	if err := layoutISOTree(tree, ISOOptions{Stboot: kernel, StbootInitramfs: initramfs}, runner); err != nil {
+		t.Fatalf("layoutISOTree() returned error: %v", err)
+	}
+
+	for _, want := range []string{"vmlinuz", "initrd", "stboot.marker", "boot/grub/grub.cfg", "EFI/BOOT"} {
+		if _, err := os.Stat(filepath.Join(tree, want)); err != nil {
+			t.Errorf("layoutISOTree() did not create %s: %v", want, err)
+		}
+	}
+
+	cfg, err := os.ReadFile(filepath.Join(tree, "boot/grub/grub.cfg"))
+	if err != nil {
+		t.Fatalf("read grub.cfg: %v", err)
+	}
+
+	if !strings.Contains(string(cfg), "search --set=root --file /stboot.marker") {
+		t.Errorf("grub.cfg = %q, missing stboot.marker search directive", cfg)
+	}
+
+	if len(runner.calls) != 1 || runner.calls[0][0] != "grub-mkstandalone" {
+		t.Fatalf("layoutISOTree() runner calls = %v, want a single grub-mkstandalone invocation", runner.calls)
+	}
+
+	if want := "--format=x86_64-efi"; !containsArg(runner.calls[0], want) {
+		t.Errorf("grub-mkstandalone call %v missing %q", runner.calls[0], want)
+	}
+
+	if want := "--output=" + filepath.Join(tree, efiExecutablePath); !containsArg(runner.calls[0], want) {
+		t.Errorf("grub-mkstandalone call %v missing %q", runner.calls[0], want)
+	}
+}
+
+// TestBuildDiskArguments asserts the exact sgdisk argument list buildDisk()
+// passes to runner, and the mcopy -s flag, image, source and destination
+// arguments, the same way TestLayoutISOTree pins down grub-mkstandalone's,
+// so a future change to partition sizing or the mcopy source spec doesn't
+// regress silently.
+func TestBuildDiskArguments(t *testing.T) {
+	root := t.TempDir()
+
+	kernel := filepath.Join(root, "kernel")
+	initramfs := filepath.Join(root, "initramfs")
+
+	if err := os.WriteFile(kernel, []byte("kernel"), 0o644); err != nil {
+		t.Fatalf("write kernel: %v", err)
+	}
+
+	if err := os.WriteFile(initramfs, []byte("initramfs"), 0o644); err != nil {
+		t.Fatalf("write initramfs: %v", err)
+	}
+
+	opts := DiskOptions{
+		Stboot:          kernel,
+		StbootInitramfs: initramfs,
+		Out:             filepath.Join(root, "out.img"),
+		SizeMB:          16,
+	}
+
+	runner := &mockRunner{}
+
+	if err := buildDisk(opts, runner); err != nil {
+		t.Fatalf("buildDisk() returned error: %v", err)
+	}
+
+	var sgdiskCall, mcopyCall []string
+
+	for _, call := range runner.calls {
+		switch call[0] {
+		case "sgdisk":
+			sgdiskCall = call
+		case "mcopy":
+			mcopyCall = call
+		}
+	}
+
+	if sgdiskCall == nil {
+		t.Fatalf("buildDisk() runner calls = %v, want an sgdisk invocation", runner.calls)
+	}
+
+	wantSgdisk := []string{
+		"sgdisk",
+		fmt.Sprintf("--new=1:%d:+%dM", espStartSector, opts.SizeMB-espStartMB-gptBackupReserveMB),
+		"--typecode=1:ef00",
+		"--change-name=1:ESP",
+		opts.Out,
+	}
+
+	if !reflect.DeepEqual(sgdiskCall, wantSgdisk) {
+		t.Fatalf("sgdisk call = %v, want %v", sgdiskCall, wantSgdisk)
+	}
+
+	if mcopyCall == nil {
+		t.Fatalf("buildDisk() runner calls = %v, want an mcopy invocation populating the ESP", runner.calls)
+	}
+
+	if want := "-s"; !containsArg(mcopyCall, want) {
+		t.Errorf("mcopy call %v missing %q", mcopyCall, want)
+	}
+
+	if !strings.HasSuffix(mcopyCall[3], "esp.img") {
+		t.Errorf("mcopy call %v, image argument %q does not name esp.img", mcopyCall, mcopyCall[3])
+	}
+
+	if !strings.HasSuffix(mcopyCall[4], "/.") {
+		t.Errorf("mcopy call %v, source argument %q should copy the whole staged tree (trailing /.)", mcopyCall, mcopyCall[4])
+	}
+
+	if mcopyCall[5] != "::" {
+		t.Errorf("mcopy call %v destination = %q, want \"::\"", mcopyCall, mcopyCall[5])
+	}
+}
+
+func TestDiskMissingToolsFailsWithClearMessage(t *testing.T) {
+	opts := DiskOptions{Stboot: "/dev/null", StbootInitramfs: "/dev/null", Out: filepath.Join(t.TempDir(), "out.img")}
+
+	err := Disk(opts, &mockRunner{})
+	if err == nil {
+		t.Fatal("Disk() with no sgdisk/mkfs.vfat on $PATH should fail")
+	}
+
+	if !strings.Contains(err.Error(), "not found on $PATH") {
+		t.Fatalf("Disk() error = %q, want a message naming the missing tool", err)
+	}
+}
+
+// TestWriteAtPlacesSrcAtOffsetWithoutDisturbingBytesBeforeIt guards against
+// the bug Disk() used to have: formatting the ESP filesystem straight onto
+// the disk image at byte 0, clobbering the protective MBR and GPT header
+// sgdisk had just written there. writeAt must place src at the partition's
+// byte offset and leave everything before it untouched.
+func TestWriteAtPlacesSrcAtOffsetWithoutDisturbingBytesBeforeIt(t *testing.T) {
+	dir := t.TempDir()
+
+	dst := filepath.Join(dir, "disk.img")
+	if err := allocateImage(dst, 4); err != nil {
+		t.Fatalf("allocateImage() = %v", err)
+	}
+
+	gptHeader := []byte("fake-protective-MBR-and-GPT-header")
+
+	f, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open disk image: %v", err)
+	}
+
+	if _, err := f.WriteAt(gptHeader, 0); err != nil {
+		t.Fatalf("write fake GPT header: %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("close disk image: %v", err)
+	}
+
+	src := filepath.Join(dir, "esp.img")
+
+	fatSignature := []byte("fake-FAT-filesystem")
+	if err := os.WriteFile(src, fatSignature, 0o644); err != nil {
+		t.Fatalf("write fake ESP image: %v", err)
+	}
+
+	offset := int64(espStartSector) * sectorSize
+
+	if err := writeAt(dst, src, offset); err != nil {
+		t.Fatalf("writeAt() = %v", err)
+	}
+
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("read disk image: %v", err)
+	}
+
+	if !bytes.HasPrefix(got, gptHeader) {
+		t.Fatalf("writeAt() disturbed the bytes before offset %d, want the GPT header %q untouched", offset, gptHeader)
+	}
+
+	if !bytes.Equal(got[offset:offset+int64(len(fatSignature))], fatSignature) {
+		t.Fatalf("writeAt() did not place the ESP image at its partition offset %d", offset)
+	}
+}
+
+func containsArg(call []string, arg string) bool {
+	for _, a := range call {
+		if a == arg {
+			return true
+		}
+	}
+
+	return false
+}
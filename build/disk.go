@@ -0,0 +1,155 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package build
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DiskOptions are the inputs to build a raw GPT disk image, usable directly
+// with "qemu -drive file=...,format=raw".
+type DiskOptions struct {
+	OSPKG           string // OS package descriptor JSON
+	Stboot          string // stboot kernel
+	StbootInitramfs string // stboot initramfs
+	HostConfig      string // host_configuration.json to embed
+	Out             string // output disk image path
+	SizeMB          int64  // total disk size, defaults to 256 MiB
+}
+
+const defaultDiskSizeMB = 256
+
+const sectorSize = 512
+
+// espStartSector is the LBA the ESP partition starts at: sector 2048, the
+// conventional 1 MiB alignment used by sgdisk and most other partitioning
+// tools, placing it safely after the protective MBR (LBA0) and the primary
+// GPT header/partition table (LBA1-33).
+const espStartSector = 2048
+
+// gptBackupReserveMB is slack left unpartitioned at the end of the disk
+// image for sgdisk's backup GPT header and partition table.
+const gptBackupReserveMB = 1
+
+// espStartMB is espStartSector expressed in MiB, for sizing arithmetic
+// against opts.SizeMB.
+const espStartMB = espStartSector * sectorSize / (1024 * 1024)
+
+// Disk writes a GPT image at opts.Out with a single EFI System Partition
+// holding the same payload as ISO, using mtools/mkfs.vfat via runner. The
+// ESP filesystem is built in a separate file and copied into opts.Out at
+// the partition's byte offset, so it doesn't clobber the protective MBR
+// and GPT header sgdisk writes at the start of the image.
+func Disk(opts DiskOptions, runner Runner) error {
+	if err := requireTools("mkfs.vfat", "sgdisk", "mcopy", "grub-mkstandalone"); err != nil {
+		return err
+	}
+
+	return buildDisk(opts, runner)
+}
+
+// buildDisk is Disk's implementation, split out so tests can drive it
+// directly with a mockRunner without requireTools rejecting a test
+// environment that doesn't have sgdisk/mkfs.vfat installed.
+func buildDisk(opts DiskOptions, runner Runner) error {
+	sizeMB := opts.SizeMB
+	if sizeMB == 0 {
+		sizeMB = defaultDiskSizeMB
+	}
+
+	espSizeMB := sizeMB - espStartMB - gptBackupReserveMB
+	if espSizeMB <= 0 {
+		return fmt.Errorf("build: disk size %dMB too small for an ESP, need more than %dMB", sizeMB, espStartMB+gptBackupReserveMB)
+	}
+
+	if err := allocateImage(opts.Out, sizeMB); err != nil {
+		return err
+	}
+
+	if err := runner.Run("sgdisk",
+		fmt.Sprintf("--new=1:%d:+%dM", espStartSector, espSizeMB),
+		"--typecode=1:ef00",
+		"--change-name=1:ESP",
+		opts.Out,
+	); err != nil {
+		return err
+	}
+
+	root, err := os.MkdirTemp("", "stmgr-build-disk-")
+	if err != nil {
+		return fmt.Errorf("build: create temp dir: %w", err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := layoutISOTree(root, ISOOptions{
+		OSPKG:           opts.OSPKG,
+		Stboot:          opts.Stboot,
+		StbootInitramfs: opts.StbootInitramfs,
+		HostConfig:      opts.HostConfig,
+	}, runner); err != nil {
+		return err
+	}
+
+	espImage := filepath.Join(root, "esp.img")
+	if err := allocateImage(espImage, espSizeMB); err != nil {
+		return err
+	}
+
+	if err := runner.Run("mkfs.vfat", "-n", "ESP", espImage); err != nil {
+		return err
+	}
+
+	// layoutISOTree already staged the standalone UEFI bootloader at
+	// EFI/BOOT/BOOTX64.EFI, so this one copy makes the ESP bootable.
+	if err := runner.Run("mcopy", "-s", "-i", espImage, root+"/.", "::"); err != nil {
+		return err
+	}
+
+	return writeAt(opts.Out, espImage, int64(espStartSector)*sectorSize)
+}
+
+// writeAt copies the contents of src into dst starting at byte offset,
+// used to place the ESP filesystem image at its partition's location
+// within the disk image without disturbing the GPT structures before it.
+func writeAt(dst, src string, offset int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("build: open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("build: open %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("build: seek %s: %w", dst, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("build: write %s: %w", dst, err)
+	}
+
+	return nil
+}
+
+func allocateImage(path string, sizeMB int64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("build: create disk image: %w", err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(sizeMB * 1024 * 1024); err != nil {
+		return fmt.Errorf("build: allocate disk image: %w", err)
+	}
+
+	return nil
+}
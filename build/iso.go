@@ -0,0 +1,253 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package build
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/system-transparency/stmgr/ospkg"
+)
+
+// cdbootCandidates are the paths grub2's cdboot.img is installed at across
+// the distributions stmgr targets.
+var cdbootCandidates = []string{
+	"/usr/lib/grub/i386-pc/cdboot.img",
+	"/usr/share/grub/i386-pc/cdboot.img",
+}
+
+// efiExecutablePath is where the standalone UEFI bootloader is staged
+// within the image tree, following the removable-media path UEFI firmware
+// falls back to when there is no boot entry in NVRAM.
+const efiExecutablePath = "EFI/BOOT/BOOTX64.EFI"
+
+// efiImageSizeMB is the size of the small FAT image used as the El Torito
+// UEFI boot image; it only has to hold a single grub-mkstandalone binary.
+const efiImageSizeMB = 4
+
+// ISOOptions are the inputs to build a stboot ISO image.
+type ISOOptions struct {
+	OSPKG           string // OS package descriptor JSON
+	Stboot          string // stboot kernel
+	StbootInitramfs string // stboot initramfs
+	HostConfig      string // host_configuration.json to embed
+	Out             string // output ISO path
+}
+
+// ISO builds a hybrid BIOS/UEFI ISO that chainloads stboot with opts's host
+// configuration embedded, using runner to invoke grub-mkstandalone and
+// xorriso.
+func ISO(opts ISOOptions, runner Runner) error {
+	if err := requireTools("grub-mkstandalone", "xorriso", "mformat", "mmd", "mcopy"); err != nil {
+		return err
+	}
+
+	root, err := os.MkdirTemp("", "stmgr-build-iso-")
+	if err != nil {
+		return fmt.Errorf("build: create temp dir: %w", err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := layoutISOTree(root, opts, runner); err != nil {
+		return err
+	}
+
+	coreImage := filepath.Join(root, "core.img")
+	if err := runner.Run("grub-mkstandalone",
+		"--format=i386-pc",
+		"--output="+coreImage,
+		"--install-modules=iso9660 biosdisk",
+		"boot/grub/grub.cfg="+filepath.Join(root, "boot/grub/grub.cfg"),
+	); err != nil {
+		return err
+	}
+
+	cdboot, err := findCdboot()
+	if err != nil {
+		return err
+	}
+
+	eltorito := filepath.Join(root, "eltorito.img")
+	if err := concat(eltorito, cdboot, coreImage); err != nil {
+		return err
+	}
+
+	efiImage := filepath.Join(root, "boot/grub/efi.img")
+	if err := buildEFIImage(efiImage, filepath.Join(root, efiExecutablePath), runner); err != nil {
+		return err
+	}
+
+	return runner.Run("xorriso",
+		"-as", "mkisofs",
+		"-iso-level", "3",
+		"-full-iso9660-filenames",
+		"-eltorito-boot", "eltorito.img",
+		"-no-emul-boot",
+		"-boot-load-size", "4",
+		"-boot-info-table",
+		"-eltorito-alt-boot",
+		"-e", "boot/grub/efi.img",
+		"-no-emul-boot",
+		"-isohybrid-gpt-basdat",
+		"-output", opts.Out,
+		root,
+	)
+}
+
+// layoutISOTree stages the kernel, initramfs, host configuration, GRUB
+// config and standalone UEFI bootloader root expects, using the
+// "search --set=root --file /stboot.marker" pattern so GRUB finds itself
+// regardless of which device it booted from.
+func layoutISOTree(root string, opts ISOOptions, runner Runner) error {
+	if err := os.MkdirAll(filepath.Join(root, "boot/grub"), 0o755); err != nil {
+		return fmt.Errorf("build: create ISO tree: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "stboot.marker"), nil, 0o644); err != nil {
+		return fmt.Errorf("build: write stboot.marker: %w", err)
+	}
+
+	if err := copyFile(filepath.Join(root, "vmlinuz"), opts.Stboot); err != nil {
+		return err
+	}
+
+	if err := copyFile(filepath.Join(root, "initrd"), opts.StbootInitramfs); err != nil {
+		return err
+	}
+
+	if opts.HostConfig != "" {
+		if err := copyFile(filepath.Join(root, "host_configuration.json"), opts.HostConfig); err != nil {
+			return err
+		}
+	}
+
+	if opts.OSPKG != "" {
+		if err := stageOSPKG(root, opts.OSPKG); err != nil {
+			return err
+		}
+	}
+
+	cfg := "search --set=root --file /stboot.marker\n" +
+		"linux /vmlinuz\n" +
+		"initrd /initrd\n" +
+		"boot\n"
+
+	if err := os.WriteFile(filepath.Join(root, "boot/grub/grub.cfg"), []byte(cfg), 0o644); err != nil {
+		return fmt.Errorf("build: write grub.cfg: %w", err)
+	}
+
+	return buildEFIExecutable(root, runner)
+}
+
+// buildEFIExecutable generates a standalone grub EFI application embedding
+// root's grub.cfg and stages it at efiExecutablePath, so UEFI firmware can
+// chainload it straight off the image's removable-media path.
+func buildEFIExecutable(root string, runner Runner) error {
+	if err := os.MkdirAll(filepath.Join(root, filepath.Dir(efiExecutablePath)), 0o755); err != nil {
+		return fmt.Errorf("build: create EFI/BOOT dir: %w", err)
+	}
+
+	return runner.Run("grub-mkstandalone",
+		"--format=x86_64-efi",
+		"--output="+filepath.Join(root, efiExecutablePath),
+		"--install-modules=part_gpt fat iso9660",
+		"boot/grub/grub.cfg="+filepath.Join(root, "boot/grub/grub.cfg"),
+	)
+}
+
+// buildEFIImage packages efiExecutable into a small FAT image at out, which
+// xorriso embeds as the El Torito boot image for the UEFI boot catalog
+// entry (CD/DVD firmware can't read the ISO's own UDF/ISO9660 tree for
+// this the way a USB stick's firmware reads its FAT partition).
+func buildEFIImage(out, efiExecutable string, runner Runner) error {
+	if err := allocateImage(out, efiImageSizeMB); err != nil {
+		return err
+	}
+
+	if err := runner.Run("mformat", "-i", out, "::"); err != nil {
+		return err
+	}
+
+	if err := runner.Run("mmd", "-i", out, "::EFI", "::EFI/BOOT"); err != nil {
+		return err
+	}
+
+	return runner.Run("mcopy", "-i", out, efiExecutable, "::EFI/BOOT/BOOTX64.EFI")
+}
+
+// stageOSPKG copies the OS package archive and descriptor into the image's
+// ospkg/ directory, so a locally booting stboot can find them without a
+// network round-trip.
+func stageOSPKG(root, ospkgPath string) error {
+	archivePath, descriptorPath, err := ospkg.Paths(ospkgPath)
+	if err != nil {
+		return fmt.Errorf("build: %w", err)
+	}
+
+	dir := filepath.Join(root, "ospkg")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("build: create ospkg dir: %w", err)
+	}
+
+	if err := copyFile(filepath.Join(dir, filepath.Base(archivePath)), archivePath); err != nil {
+		return err
+	}
+
+	return copyFile(filepath.Join(dir, filepath.Base(descriptorPath)), descriptorPath)
+}
+
+func findCdboot() (string, error) {
+	for _, path := range cdbootCandidates {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("build: cdboot.img not found, tried %v (install grub-pc-bin)", cdbootCandidates)
+}
+
+func concat(out string, parts ...string) error {
+	dst, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("build: create %s: %w", out, err)
+	}
+	defer dst.Close()
+
+	for _, part := range parts {
+		src, err := os.Open(part)
+		if err != nil {
+			return fmt.Errorf("build: open %s: %w", part, err)
+		}
+
+		_, err = io.Copy(dst, src)
+		src.Close()
+
+		if err != nil {
+			return fmt.Errorf("build: write %s: %w", out, err)
+		}
+	}
+
+	return nil
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("build: open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("build: create %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+
+	return err
+}
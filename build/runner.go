@@ -0,0 +1,57 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package build implements "stmgr build iso" and "stmgr build disk": turning
+// an OS package plus a stboot kernel/initramfs into a bootable image.
+package build
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Runner executes an external tool. It is an interface so tests can mock
+// out grub-mkstandalone and xorriso instead of actually invoking them.
+type Runner interface {
+	Run(name string, args ...string) error
+}
+
+// execRunner is the Runner used outside of tests: it shells out to the
+// named binary on $PATH.
+type execRunner struct{}
+
+// NewRunner returns the Runner that actually invokes external tools.
+func NewRunner() Runner {
+	return execRunner{}
+}
+
+func (execRunner) Run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("build: %s %v: %w\n%s", name, args, err, out)
+	}
+
+	return nil
+}
+
+// requireTools checks that every named binary is on $PATH, returning a
+// single error listing everything that's missing so users get one clear
+// message instead of failing midway through the build.
+func requireTools(names ...string) error {
+	var missing []string
+
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("build: required tool(s) not found on $PATH: %v", missing)
+	}
+
+	return nil
+}
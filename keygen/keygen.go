@@ -0,0 +1,233 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package keygen implements the "keygen certificate" subcommand: generating
+// ED25519 root CA and signer certificates for OS package signing.
+package keygen
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/system-transparency/stmgr/kms"
+)
+
+const (
+	defaultValidity  = 72 * time.Hour
+	defaultCertOut   = "cert.pem"
+	defaultKeyOut    = "key.pem"
+	defaultCAOut     = "rootcert.pem"
+	defaultCAKeyOut  = "rootkey.pem"
+	certFilePerm     = 0o644
+	keyFilePerm      = 0o600
+	dateLayoutRFC822 = time.RFC822
+)
+
+// Run generates an ED25519 certificate. If isCA is set, a self-signed root
+// certificate is created; otherwise rootCert/rootKey sign the new
+// certificate. If kmsURI is non-empty, the private key is generated by that
+// key manager via kms.Generate instead of being written to keyOut; only the
+// softkms backend supports this today (see kms.Generate), and the key ends
+// up at the path in kmsURI rather than at keyOut.
+func Run(isCA bool, rootCert, rootKey, validFrom, validUntil, certOut, keyOut, kmsURI string) error {
+	notBefore, notAfter, err := validity(validFrom, validUntil)
+	if err != nil {
+		return err
+	}
+
+	pub, signer, writeKey, err := newKeyPair(kmsURI)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "stmgr"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+
+	var (
+		parent    *x509.Certificate
+		parentKey any = signer
+	)
+
+	if isCA {
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent = template
+		certOut = orDefault(certOut, defaultCAOut)
+		keyOut = orDefault(keyOut, defaultCAKeyOut)
+	} else {
+		parent, parentKey, err = loadRoot(rootCert, rootKey)
+		if err != nil {
+			return err
+		}
+		certOut = orDefault(certOut, defaultCertOut)
+		keyOut = orDefault(keyOut, defaultKeyOut)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		return fmt.Errorf("keygen: create certificate: %w", err)
+	}
+
+	if err := writePEM(certOut, "CERTIFICATE", der, certFilePerm); err != nil {
+		return err
+	}
+
+	return writeKey(keyOut)
+}
+
+// validity parses the -validFrom/-validUntil RFC822 flags, defaulting to
+// now and to 72h after notBefore respectively.
+func validity(validFrom, validUntil string) (notBefore, notAfter time.Time, err error) {
+	notBefore = time.Now()
+	if validFrom != "" {
+		notBefore, err = time.Parse(dateLayoutRFC822, validFrom)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("keygen: parse -validFrom: %w", err)
+		}
+	}
+
+	notAfter = notBefore.Add(defaultValidity)
+	if validUntil != "" {
+		notAfter, err = time.Parse(dateLayoutRFC822, validUntil)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("keygen: parse -validUntil: %w", err)
+		}
+	}
+
+	return notBefore, notAfter, nil
+}
+
+// newKeyPair returns the public key to embed in the certificate, the signer
+// used to self/parent-sign it, and a function that persists the private key
+// material once the caller knows the final keyOut path. When kmsURI is set,
+// the key is generated by that key manager (kms.Generate) rather than here,
+// and writeKey is a no-op since the key manager already persisted it.
+func newKeyPair(kmsURI string) (pub ed25519.PublicKey, signer any, writeKey func(path string) error, err error) {
+	if kmsURI != "" {
+		s, err := kms.Generate(kmsURI)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("keygen: %w", err)
+		}
+
+		return newKMSKeyPair(kmsURI, s)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("keygen: generate key: %w", err)
+	}
+
+	return pub, priv, func(path string) error {
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return fmt.Errorf("keygen: marshal key: %w", err)
+		}
+
+		return writePEM(path, "PRIVATE KEY", der, keyFilePerm)
+	}, nil
+}
+
+// newKMSKeyPair resolves s (normally obtained from kms.Generate(kmsURI)) into the
+// public key and crypto.Signer newKeyPair needs. It is split out from
+// newKeyPair so the kms.Signer resolution logic can be tested against a
+// mock, without a real kms backend behind kmsURI.
+func newKMSKeyPair(kmsURI string, s kms.Signer) (pub ed25519.PublicKey, signer any, writeKey func(path string) error, err error) {
+	kmsPub, err := s.Public()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("keygen: -kms %q does not have an ED25519 public key available: %w", kmsURI, err)
+	}
+
+	return kmsPub, &kmsCryptoSigner{Signer: s, pub: kmsPub}, func(string) error { return nil }, nil
+}
+
+// kmsCryptoSigner adapts a kms.Signer, whose Sign method takes the raw
+// message to sign, to crypto.Signer, which x509.CreateCertificate requires
+// of its parent key.
+type kmsCryptoSigner struct {
+	kms.Signer
+	pub ed25519.PublicKey
+}
+
+func (s *kmsCryptoSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *kmsCryptoSigner) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	return s.Signer.Sign(digest)
+}
+
+func loadRoot(rootCertPath, rootKeyPath string) (*x509.Certificate, any, error) {
+	if rootCertPath == "" || rootKeyPath == "" {
+		return nil, nil, fmt.Errorf("keygen: -rootCert and -rootKey are required unless -isCA is set")
+	}
+
+	certPEM, err := os.ReadFile(rootCertPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("keygen: read -rootCert: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("keygen: no PEM block found in %s", rootCertPath)
+	}
+
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("keygen: parse -rootCert: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(rootKeyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("keygen: read -rootKey: %w", err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("keygen: no PEM block found in %s", rootKeyPath)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("keygen: parse -rootKey: %w", err)
+	}
+
+	return cert, key, nil
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+
+	return value
+}
+
+func serialNumber() *big.Int {
+	max := new(big.Int).Lsh(big.NewInt(1), 128)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+
+	return n
+}
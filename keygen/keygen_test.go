@@ -0,0 +1,102 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keygen
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mockSigner is a kms.Signer backed by a real ED25519 key but no disk or
+// HSM, used to exercise the -kms certificate generation path.
+type mockSigner struct {
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+	err  error
+}
+
+func (m *mockSigner) Sign(digest []byte) ([]byte, error) {
+	return ed25519.Sign(m.priv, digest), nil
+}
+
+func (m *mockSigner) Public() (ed25519.PublicKey, error) {
+	return m.pub, m.err
+}
+
+func (m *mockSigner) Certificate() (*x509.Certificate, error) {
+	return nil, errors.New("mock kms: no certificate")
+}
+
+// TestNewKMSKeyPairProducesAUsableCryptoSigner covers the -kms certificate
+// generation path that a156b12 had to fix: x509.CreateCertificate needs a
+// crypto.Signer whose Public() and Sign() work without ever calling
+// kms.Signer.Certificate(), which a -kms signer generating a brand new key
+// does not have yet.
+func TestNewKMSKeyPairProducesAUsableCryptoSigner(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	mock := &mockSigner{priv: priv, pub: pub}
+
+	gotPub, signer, writeKey, err := newKMSKeyPair("mock:test", mock)
+	if err != nil {
+		t.Fatalf("newKMSKeyPair() = %v", err)
+	}
+
+	if !gotPub.Equal(pub) {
+		t.Fatalf("newKMSKeyPair() pub = %x, want %x", gotPub, pub)
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := writeKey(path); err != nil {
+		t.Fatalf("writeKey() = %v", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("writeKey() for a -kms signer wrote %s, want no file since the key lives in the KMS", path)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stmgr"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, gotPub, signer)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() with a -kms signer = %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse generated certificate: %v", err)
+	}
+
+	if err := cert.CheckSignatureFrom(cert); err != nil {
+		t.Fatalf("certificate signed by the -kms signer does not verify: %v", err)
+	}
+}
+
+func TestNewKMSKeyPairRequiresED25519PublicKey(t *testing.T) {
+	mock := &mockSigner{err: errors.New("token not provisioned")}
+
+	if _, _, _, err := newKMSKeyPair("mock:test", mock); err == nil {
+		t.Fatal("newKMSKeyPair() with a signer that can't report a public key succeeded, want an error")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package kms provides a URI-based abstraction over the private keys used
+// to sign OS packages and certificates, so that stmgr does not need to know
+// whether a key lives in a file on disk, inside an HSM, or in a cloud KMS.
+package kms
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/system-transparency/stmgr/kms/pkcs11"
+	"github.com/system-transparency/stmgr/kms/softkms"
+)
+
+// Signer is implemented by every key manager backend. It abstracts a single
+// private key together with the certificate that vouches for it.
+type Signer interface {
+	// Sign returns the signature over digest, which is the raw message to
+	// be signed (stmgr uses ED25519 keys, so digest is not pre-hashed).
+	Sign(digest []byte) ([]byte, error)
+
+	// Public returns the ED25519 public key corresponding to the signing
+	// key, or an error if the backend cannot report one (e.g. a token that
+	// has not been provisioned yet).
+	Public() (ed25519.PublicKey, error)
+
+	// Certificate returns the certificate corresponding to the signing key,
+	// or an error if the backend does not have one available.
+	Certificate() (*x509.Certificate, error)
+}
+
+// New parses uri and returns the Signer backend it selects, loading an
+// already-existing key. The scheme before the first ':' picks the backend
+// and the remainder is backend specific:
+//
+//	softkms:./key.pem                      file-based key, current default behavior
+//	pkcs11:slot-id=0;object=stmgr-signer    PKCS#11 token (not yet implemented)
+//	awskms:///alias/stmgr                   AWS KMS (not yet implemented)
+//	yubikey:slot=9c                         YubiKey PIV slot (not yet implemented)
+//
+// A bare path with no scheme is treated as softkms:<path> for backwards
+// compatibility with the old -key flag.
+func New(uri string) (Signer, error) {
+	scheme, rest, ok := strings.Cut(uri, ":")
+	if !ok {
+		return softkms.New(uri)
+	}
+
+	switch scheme {
+	case "softkms":
+		return softkms.New(rest)
+	case "pkcs11":
+		return pkcs11.New(rest)
+	case "awskms":
+		return nil, fmt.Errorf("kms: awskms backend not implemented yet")
+	case "yubikey":
+		return nil, fmt.Errorf("kms: yubikey backend not implemented yet")
+	default:
+		return nil, fmt.Errorf("kms: unknown key URI scheme %q", scheme)
+	}
+}
+
+// Generate creates a brand new key inside the backend uri selects (see
+// New for the URI format) instead of loading one that already exists. Of
+// the backends above, only softkms supports this today: it generates an
+// ED25519 key and writes it to the path in the URI, so "keygen -kms
+// softkms:./key.pem" never has the caller handle the key material
+// directly. The other backends return an error until they grow their own
+// key-creation support.
+func Generate(uri string) (Signer, error) {
+	scheme, rest, ok := strings.Cut(uri, ":")
+	if !ok {
+		return softkms.Generate(uri)
+	}
+
+	switch scheme {
+	case "softkms":
+		return softkms.Generate(rest)
+	case "pkcs11":
+		return nil, fmt.Errorf("kms: pkcs11 backend does not support key generation yet")
+	case "awskms":
+		return nil, fmt.Errorf("kms: awskms backend not implemented yet")
+	case "yubikey":
+		return nil, fmt.Errorf("kms: yubikey backend not implemented yet")
+	default:
+		return nil, fmt.Errorf("kms: unknown key URI scheme %q", scheme)
+	}
+}
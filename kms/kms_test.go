@@ -0,0 +1,64 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kms
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+// mockSigner is a Signer used by tests that don't want to touch disk or a
+// real HSM.
+type mockSigner struct {
+	sig []byte
+}
+
+func (m *mockSigner) Sign(digest []byte) ([]byte, error) {
+	return m.sig, nil
+}
+
+func (m *mockSigner) Public() (ed25519.PublicKey, error) {
+	return nil, nil
+}
+
+func (m *mockSigner) Certificate() (*x509.Certificate, error) {
+	return &x509.Certificate{}, nil
+}
+
+func TestMockSignerSatisfiesSigner(t *testing.T) {
+	var s Signer = &mockSigner{sig: []byte("signature")}
+
+	got, err := s.Sign([]byte("digest"))
+	if err != nil {
+		t.Fatalf("Sign returned error: %v", err)
+	}
+
+	if string(got) != "signature" {
+		t.Fatalf("Sign() = %q, want %q", got, "signature")
+	}
+
+	if _, err := s.Certificate(); err != nil {
+		t.Fatalf("Certificate returned error: %v", err)
+	}
+}
+
+func TestNewUnknownScheme(t *testing.T) {
+	if _, err := New("made-up-scheme:foo"); err == nil {
+		t.Fatal("New() with an unknown scheme should return an error")
+	}
+}
+
+func TestNewBarePathDefaultsToSoftkms(t *testing.T) {
+	_, err := New("/nonexistent/key.pem")
+	if err == nil {
+		t.Fatal("New() with a nonexistent key file should return an error")
+	}
+
+	if !strings.HasPrefix(err.Error(), "softkms:") {
+		t.Fatalf("New() error = %q, want an error from the softkms backend", err)
+	}
+}
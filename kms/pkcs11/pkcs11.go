@@ -0,0 +1,72 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkcs11 is the placeholder kms.Signer backend for PKCS#11 tokens.
+// It is not implemented yet: New validates the key URI and then returns an
+// error, the same as the awskms/yubikey schemes in kms.New. It exists as
+// its own package, rather than an inline error in kms.New, only because the
+// URI shape (module path and PIN from PKCS11_MODULE/PKCS11_PIN, slot/object
+// from the URI itself, mirroring go.step.sm/crypto/kms/apiv1/pkcs11) is
+// worth validating and documenting ahead of a real implementation.
+package pkcs11
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+)
+
+// Token is the kms.Signer type New would return once the backend is
+// implemented. It carries no token/session state today because New never
+// actually returns one: every method below is unreachable until that
+// happens.
+type Token struct{}
+
+// New validates the PKCS#11 session uri describes, which is the part of the
+// key URI following the "pkcs11:" scheme, e.g. "slot-id=0;object=stmgr-signer",
+// and then reports that the backend itself isn't implemented yet.
+func New(uri string) (*Token, error) {
+	values, err := url.ParseQuery(toQuery(uri))
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: parse key URI: %w", err)
+	}
+
+	if values.Get("object") == "" {
+		return nil, fmt.Errorf("pkcs11: key URI is missing the \"object\" attribute")
+	}
+
+	return nil, fmt.Errorf("pkcs11: backend is not implemented yet, see https://github.com/miekg/pkcs11")
+}
+
+// toQuery turns the ';'-separated PKCS#11 URI attributes into the '&'-separated
+// form url.ParseQuery expects.
+func toQuery(uri string) string {
+	out := make([]byte, len(uri))
+
+	for i := range uri {
+		if uri[i] == ';' {
+			out[i] = '&'
+		} else {
+			out[i] = uri[i]
+		}
+	}
+
+	return string(out)
+}
+
+// Sign is unreachable: see New.
+func (t *Token) Sign(digest []byte) ([]byte, error) {
+	return nil, fmt.Errorf("pkcs11: backend is not implemented yet")
+}
+
+// Public is unreachable: see New.
+func (t *Token) Public() (ed25519.PublicKey, error) {
+	return nil, fmt.Errorf("pkcs11: backend is not implemented yet")
+}
+
+// Certificate is unreachable: see New.
+func (t *Token) Certificate() (*x509.Certificate, error) {
+	return nil, fmt.Errorf("pkcs11: backend is not implemented yet")
+}
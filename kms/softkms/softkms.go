@@ -0,0 +1,104 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package softkms implements the file-based key manager backend, i.e. the
+// plain "read a PEM private key from disk" behavior stmgr has always had.
+// It exists so that softkms can be selected explicitly via a
+// "softkms:<path>" key URI and treated like any other kms backend.
+package softkms
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// SoftKMS is a Signer backed by an ED25519 private key stored unencrypted
+// on disk in PEM format.
+type SoftKMS struct {
+	key  ed25519.PrivateKey
+	cert *x509.Certificate
+}
+
+// New loads the ED25519 private key at path. The certificate is not known
+// at load time and has to be supplied separately with the -cert flag, so
+// Certificate returns an error until SetCertificate is called.
+func New(path string) (*SoftKMS, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("softkms: read key: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("softkms: no PEM block found in %s", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("softkms: parse key: %w", err)
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("softkms: key in %s is not an ED25519 key", path)
+	}
+
+	return &SoftKMS{key: edKey}, nil
+}
+
+// Generate creates a new ED25519 key and writes it, PKCS8/PEM-encoded, to
+// path (0600), then returns a SoftKMS backed by it. It is the softkms
+// backend for "keygen -kms", so that a key can be created without the
+// caller ever handling the private key bytes directly.
+func Generate(path string) (*SoftKMS, error) {
+	if path == "" {
+		return nil, fmt.Errorf("softkms: -kms softkms: requires a path to write the new key to")
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("softkms: generate key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("softkms: marshal key: %w", err)
+	}
+
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		return nil, fmt.Errorf("softkms: write key: %w", err)
+	}
+
+	return &SoftKMS{key: priv}, nil
+}
+
+// Sign signs digest with the loaded ED25519 key.
+func (s *SoftKMS) Sign(digest []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, digest), nil
+}
+
+// Public returns the ED25519 public key half of the loaded private key.
+func (s *SoftKMS) Public() (ed25519.PublicKey, error) {
+	return s.key.Public().(ed25519.PublicKey), nil
+}
+
+// SetCertificate attaches the certificate corresponding to the key, as read
+// from the -cert flag by the caller.
+func (s *SoftKMS) SetCertificate(cert *x509.Certificate) {
+	s.cert = cert
+}
+
+// Certificate returns the certificate previously set with SetCertificate.
+func (s *SoftKMS) Certificate() (*x509.Certificate, error) {
+	if s.cert == nil {
+		return nil, fmt.Errorf("softkms: no certificate set, pass -cert")
+	}
+
+	return s.cert, nil
+}
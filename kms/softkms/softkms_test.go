@@ -0,0 +1,153 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package softkms
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewLoadsAndSignsWithARealKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+
+	gotPub, err := s.Public()
+	if err != nil {
+		t.Fatalf("Public() = %v", err)
+	}
+
+	if !gotPub.Equal(pub) {
+		t.Fatalf("Public() = %x, want %x", gotPub, pub)
+	}
+
+	digest := []byte("digest")
+
+	sig, err := s.Sign(digest)
+	if err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	if !ed25519.Verify(pub, digest, sig) {
+		t.Fatal("Sign() produced a signature that does not verify against the loaded key")
+	}
+}
+
+func TestNewRejectsMissingFile(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "no-such-key.pem")); err == nil {
+		t.Fatal("New() with a nonexistent key file should return an error")
+	}
+}
+
+func TestGenerateWritesAUsableKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "new-key.pem")
+
+	s, err := Generate(path)
+	if err != nil {
+		t.Fatalf("Generate() = %v", err)
+	}
+
+	pub, err := s.Public()
+	if err != nil {
+		t.Fatalf("Public() = %v", err)
+	}
+
+	digest := []byte("digest")
+
+	sig, err := s.Sign(digest)
+	if err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	if !ed25519.Verify(pub, digest, sig) {
+		t.Fatal("Generate() returned a signer whose own signature does not verify")
+	}
+
+	// The key must actually have been written to path, so that a backend
+	// that currently only supports softkms still leaves behind a key file
+	// the caller can find and re-load with New.
+	reloaded, err := New(path)
+	if err != nil {
+		t.Fatalf("New() on the path Generate() wrote = %v", err)
+	}
+
+	reloadedPub, err := reloaded.Public()
+	if err != nil {
+		t.Fatalf("Public() on reloaded key = %v", err)
+	}
+
+	if !reloadedPub.Equal(pub) {
+		t.Fatalf("reloaded key = %x, want %x", reloadedPub, pub)
+	}
+}
+
+func TestGenerateRequiresPath(t *testing.T) {
+	if _, err := Generate(""); err == nil {
+		t.Fatal("Generate(\"\") should return an error, there is nowhere to write the key")
+	}
+}
+
+func TestCertificateRequiresSetCertificate(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+
+	if _, err := s.Certificate(); err == nil {
+		t.Fatal("Certificate() before SetCertificate should return an error")
+	}
+
+	cert := &x509.Certificate{Raw: []byte("cert")}
+	s.SetCertificate(cert)
+
+	got, err := s.Certificate()
+	if err != nil {
+		t.Fatalf("Certificate() after SetCertificate = %v", err)
+	}
+
+	if got != cert {
+		t.Fatalf("Certificate() = %v, want the certificate passed to SetCertificate", got)
+	}
+}
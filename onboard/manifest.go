@@ -0,0 +1,70 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package onboard
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SignerEntry records the certificate/key pair generated for one signer.
+type SignerEntry struct {
+	Cert   string `yaml:"cert"`
+	Key    string `yaml:"key"`
+	Signed bool   `yaml:"signed"`
+}
+
+// Manifest records the provenance of everything onboard generated, so a
+// later run can detect what's already done and resume from there.
+type Manifest struct {
+	RootCert       string        `yaml:"root_cert,omitempty"`
+	RootKey        string        `yaml:"root_key,omitempty"`
+	Signers        []SignerEntry `yaml:"signers,omitempty"`
+	OSPkgArchive   string        `yaml:"ospkg_archive,omitempty"`
+	OSPkgDesc      string        `yaml:"ospkg_descriptor,omitempty"`
+	HostConfigPath string        `yaml:"host_configuration,omitempty"`
+}
+
+// loadManifest reads the manifest at path, returning an empty Manifest if
+// it does not exist yet.
+func loadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Manifest{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("onboard: read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("onboard: parse manifest: %w", err)
+	}
+
+	return &m, nil
+}
+
+// save writes m to path as YAML.
+func (m *Manifest) save(path string) error {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("onboard: marshal manifest: %w", err)
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// allSigned reports whether every signer in the manifest has produced a
+// signature on the OS package.
+func (m *Manifest) allSigned() bool {
+	for _, s := range m.Signers {
+		if !s.Signed {
+			return false
+		}
+	}
+
+	return len(m.Signers) > 0
+}
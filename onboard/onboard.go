@@ -0,0 +1,303 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package onboard implements "stmgr onboard": a single guided flow that
+// bootstraps a full signing setup end-to-end (root CA, signer certs, an OS
+// package, signatures and a host configuration) instead of requiring users
+// to chain keygen/ospkg/sign/provision by hand. It is modeled after the
+// step-ca onboarding flow: it is resumable, detecting on startup which
+// artifacts already exist and skipping those steps.
+//
+// This is a line-oriented bufio.Scanner prompt over stdin/stdout, not a
+// terminal UI: stmgr has no TUI library, and provision (the only other
+// package that touches a host configuration interactively) is a plain
+// JSON/efivarfs marshaler with no UI code to build on. A real TUI is
+// future work if stmgr ever grows one.
+//
+// This falls short of what was asked for: the request wanted a single TUI
+// reusing provision's TUI primitives. Since neither stmgr nor provision
+// have any UI code, that isn't buildable as described; this package is a
+// disclosed compromise (resumability and the workspace layout are
+// implemented, the UI isn't) rather than a silent substitution, and should
+// be flagged back to whoever filed the request rather than taken as
+// satisfying it.
+package onboard
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/system-transparency/stmgr/keygen"
+	"github.com/system-transparency/stmgr/ospkg"
+	"github.com/system-transparency/stmgr/provision"
+	"github.com/system-transparency/stmgr/sign"
+)
+
+const (
+	defaultWorkspace = "stmgr-workspace"
+	caDir            = "ca"
+	signersDir       = "signers"
+	ospkgDir         = "ospkg"
+	hostconfigDir    = "hostconfig"
+	manifestName     = "manifest.yaml"
+)
+
+// Options configures a single "stmgr onboard" run.
+type Options struct {
+	Workspace string // defaults to "./stmgr-workspace"
+	Signers   int    // number of signer certs to generate, defaults to 1
+}
+
+// Run walks the user through the onboarding flow on the real terminal.
+func Run(opts Options) error {
+	return RunWithIO(opts, os.Stdin, os.Stdout)
+}
+
+// RunWithIO is Run with the prompt input/output made explicit, so the flow
+// can be driven from tests without a real terminal.
+func RunWithIO(opts Options, in io.Reader, out io.Writer) error {
+	if opts.Workspace == "" {
+		opts.Workspace = defaultWorkspace
+	}
+
+	if opts.Signers == 0 {
+		opts.Signers = 1
+	}
+
+	for _, dir := range []string{caDir, signersDir, ospkgDir, hostconfigDir} {
+		if err := os.MkdirAll(filepath.Join(opts.Workspace, dir), 0o755); err != nil {
+			return fmt.Errorf("onboard: create workspace: %w", err)
+		}
+	}
+
+	manifestPath := filepath.Join(opts.Workspace, manifestName)
+
+	manifest, err := loadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewScanner(in)
+
+	if manifest.RootCert == "" {
+		fmt.Fprintln(out, "==> generating root CA")
+
+		if err := generateCA(opts.Workspace, manifest); err != nil {
+			return err
+		}
+
+		if err := manifest.save(manifestPath); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(out, "==> root CA already present, skipping")
+	}
+
+	if len(manifest.Signers) < opts.Signers {
+		fmt.Fprintln(out, "==> generating signer certificates")
+
+		if err := generateSigners(opts.Workspace, opts.Signers, manifest); err != nil {
+			return err
+		}
+
+		if err := manifest.save(manifestPath); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(out, "==> signer certificates already present, skipping")
+	}
+
+	if manifest.OSPkgDesc == "" {
+		fmt.Fprintln(out, "==> building OS package")
+
+		if err := buildOSPackage(opts.Workspace, manifest, r, out); err != nil {
+			return err
+		}
+
+		if err := manifest.save(manifestPath); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(out, "==> OS package already present, skipping")
+	}
+
+	if !manifest.allSigned() {
+		fmt.Fprintln(out, "==> collecting signatures")
+
+		if err := collectSignatures(manifest); err != nil {
+			return err
+		}
+
+		if err := manifest.save(manifestPath); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(out, "==> OS package already signed by every signer, skipping")
+	}
+
+	if manifest.HostConfigPath == "" {
+		fmt.Fprintln(out, "==> writing host configuration")
+
+		if err := writeHostConfig(opts.Workspace, manifest, r, out); err != nil {
+			return err
+		}
+
+		if err := manifest.save(manifestPath); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(out, "==> host configuration already present, skipping")
+	}
+
+	fmt.Fprintf(out, "==> done, see %s for the full provenance\n", manifestPath)
+
+	return nil
+}
+
+func generateCA(workspace string, manifest *Manifest) error {
+	rootCert := filepath.Join(workspace, caDir, "rootcert.pem")
+	rootKey := filepath.Join(workspace, caDir, "rootkey.pem")
+
+	if err := keygen.Run(true, "", "", "", "", rootCert, rootKey, ""); err != nil {
+		return fmt.Errorf("onboard: generate root CA: %w", err)
+	}
+
+	manifest.RootCert = rootCert
+	manifest.RootKey = rootKey
+
+	return nil
+}
+
+func generateSigners(workspace string, n int, manifest *Manifest) error {
+	for i := len(manifest.Signers); i < n; i++ {
+		cert := filepath.Join(workspace, signersDir, fmt.Sprintf("signer-%d-cert.pem", i))
+		key := filepath.Join(workspace, signersDir, fmt.Sprintf("signer-%d-key.pem", i))
+
+		if err := keygen.Run(false, manifest.RootCert, manifest.RootKey, "", "", cert, key, ""); err != nil {
+			return fmt.Errorf("onboard: generate signer %d: %w", i, err)
+		}
+
+		manifest.Signers = append(manifest.Signers, SignerEntry{Cert: cert, Key: key})
+	}
+
+	return nil
+}
+
+func buildOSPackage(workspace string, manifest *Manifest, r *bufio.Scanner, out io.Writer) error {
+	kernel := prompt(r, out, "kernel path", "")
+	initramfs := prompt(r, out, "initramfs path (optional)", "")
+	cmdline := prompt(r, out, "kernel cmdline (optional)", "")
+
+	descriptorOut := filepath.Join(workspace, ospkgDir, "os-package")
+
+	if err := ospkg.Run(descriptorOut, "", "", kernel, initramfs, cmdline); err != nil {
+		return fmt.Errorf("onboard: build OS package: %w", err)
+	}
+
+	archivePath, descriptorPath, err := ospkg.Paths(descriptorOut)
+	if err != nil {
+		return fmt.Errorf("onboard: %w", err)
+	}
+
+	manifest.OSPkgArchive = archivePath
+	manifest.OSPkgDesc = descriptorPath
+
+	return nil
+}
+
+func collectSignatures(manifest *Manifest) error {
+	for i := range manifest.Signers {
+		if manifest.Signers[i].Signed {
+			continue
+		}
+
+		s := &manifest.Signers[i]
+
+		key := "softkms:" + s.Key
+		if err := sign.Run(sign.Options{Key: key, Cert: s.Cert, OSPKG: manifest.OSPkgDesc, SignerID: s.Cert}); err != nil {
+			return fmt.Errorf("onboard: sign with %s: %w", s.Cert, err)
+		}
+
+		s.Signed = true
+	}
+
+	return nil
+}
+
+func writeHostConfig(workspace string, manifest *Manifest, r *bufio.Scanner, out io.Writer) error {
+	addrMode := prompt(r, out, "network mode (static/dhcp)", "dhcp")
+	hostIP := prompt(r, out, "host IP (CIDR, only for static)", "")
+	gateway := prompt(r, out, "gateway", "")
+	dns := prompt(r, out, "dns", "")
+	iface := prompt(r, out, "network interface", "")
+	urls := prompt(r, out, "provisioning URLs (comma separated)", "")
+	id := prompt(r, out, "identity", "")
+	auth := prompt(r, out, "authentication", "")
+
+	cfg := &provision.HostCfgSimplified{
+		Version:          1,
+		IPAddrMode:       &addrMode,
+		HostIP:           &hostIP,
+		DefaultGateway:   &gateway,
+		DNSServer:        &dns,
+		NetworkInterface: &iface,
+		ProvisioningURLs: splitNonEmpty(urls),
+		ID:               &id,
+		Auth:             &auth,
+	}
+
+	path := filepath.Join(workspace, hostconfigDir, "host_configuration.json")
+	if err := provision.MarshalCfgToFile(cfg, path); err != nil {
+		return fmt.Errorf("onboard: write host configuration: %w", err)
+	}
+
+	manifest.HostConfigPath = path
+
+	return nil
+}
+
+// prompt writes label (with default, if any) to out, reads one line from r,
+// and falls back to def when the line is empty.
+func prompt(r *bufio.Scanner, out io.Writer, label, def string) string {
+	if def != "" {
+		fmt.Fprintf(out, "%s [%s]: ", label, def)
+	} else {
+		fmt.Fprintf(out, "%s: ", label)
+	}
+
+	if !r.Scan() {
+		return def
+	}
+
+	if line := r.Text(); line != "" {
+		return line
+	}
+
+	return def
+}
+
+func splitNonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	var out []string
+
+	start := 0
+
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || s[i] == ',' {
+			if s[start:i] != "" {
+				out = append(out, s[start:i])
+			}
+
+			start = i + 1
+		}
+	}
+
+	return out
+}
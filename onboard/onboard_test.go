@@ -0,0 +1,55 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package onboard
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunWithIOIsResumable(t *testing.T) {
+	workspace := t.TempDir()
+
+	kernel := filepath.Join(workspace, "kernel")
+	if err := os.WriteFile(kernel, []byte("kernel"), 0o644); err != nil {
+		t.Fatalf("write fake kernel: %v", err)
+	}
+
+	answers := strings.NewReader(kernel + "\n\n\nstatic\n10.0.0.2/24\n10.0.0.1\n10.0.0.1\neth0\nhttps://example.com\nnode1\nauth\n")
+
+	var out bytes.Buffer
+
+	if err := RunWithIO(Options{Workspace: workspace, Signers: 1}, answers, &out); err != nil {
+		t.Fatalf("RunWithIO() returned error: %v", err)
+	}
+
+	manifestPath := filepath.Join(workspace, manifestName)
+
+	m, err := loadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("loadManifest() returned error: %v", err)
+	}
+
+	if m.RootCert == "" || m.OSPkgDesc == "" || m.HostConfigPath == "" {
+		t.Fatalf("manifest incomplete after first run: %+v", m)
+	}
+
+	if !m.allSigned() {
+		t.Fatalf("manifest not fully signed after first run: %+v", m)
+	}
+
+	out.Reset()
+
+	if err := RunWithIO(Options{Workspace: workspace, Signers: 1}, strings.NewReader(""), &out); err != nil {
+		t.Fatalf("resumed RunWithIO() returned error: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "already present, skipping") {
+		t.Errorf("resumed run did not skip completed steps, output: %s", out.String())
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ospkg implements the OS package format: an archive ZIP file
+// holding the kernel, initramfs and command line, plus a descriptor JSON
+// file carrying metadata and signatures over the archive.
+package ospkg
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/system-transparency/stmgr/rekor"
+)
+
+const (
+	archiveExt    = ".zip"
+	descriptorExt = ".json"
+	defaultName   = "system-transparency-os-package"
+
+	kernelEntry    = "kernel"
+	initramfsEntry = "initramfs"
+	cmdlineEntry   = "cmdline"
+
+	// signingDomain is prepended to the archive hash before it is signed,
+	// so an OS package signature can never be replayed as a signature over
+	// some unrelated SHA-256 digest. Third-party signers must reproduce
+	// this to interoperate: signed bytes = SHA256(signingDomain || SHA256(archive)).
+	signingDomain = "stmgr-ospkg-signature-v1"
+)
+
+// Signature is a single detached signature over an OS package archive,
+// together with the certificate and identifier of the signer that produced
+// it. signer_id lets a verifier require signatures from specific, distinct
+// roles (e.g. "release-manager", "security-officer") rather than just a
+// count.
+type Signature struct {
+	SignerID    string `json:"signer_id,omitempty"`
+	Certificate []byte `json:"certificate"`
+	Signature   []byte `json:"signature"`
+}
+
+// Descriptor is the metadata stboot reads to identify and verify an OS
+// package. It is stored next to the archive as "<name>.json".
+type Descriptor struct {
+	Label        string       `json:"label"`
+	URL          string       `json:"url,omitempty"`
+	Cmdline      string       `json:"cmdline"`
+	Signatures   []Signature  `json:"signatures,omitempty"`
+	Transparency *rekor.Entry `json:"transparency,omitempty"`
+}
+
+// Run creates an OS package from kernel, initramfs and cmdline, writing the
+// archive and descriptor next to each other at out (a directory or a file
+// name prefix).
+func Run(out, label, url, kernel, initramfs, cmdline string) error {
+	if kernel == "" {
+		return fmt.Errorf("ospkg: -kernel is required")
+	}
+
+	archivePath, descriptorPath, err := paths(out)
+	if err != nil {
+		return err
+	}
+
+	if label == "" {
+		label = fmt.Sprintf("System Transparency OS package %s", filepath.Base(kernel))
+	}
+
+	if err := writeArchive(archivePath, kernel, initramfs, cmdline); err != nil {
+		return err
+	}
+
+	descriptor := &Descriptor{
+		Label:   label,
+		URL:     url,
+		Cmdline: cmdline,
+	}
+
+	return writeDescriptor(descriptorPath, descriptor)
+}
+
+// paths turns the -out flag into the archive and descriptor paths. If out is
+// a directory (or empty), the default name is used inside it.
+func paths(out string) (archivePath, descriptorPath string, err error) {
+	if out == "" {
+		out = defaultName
+	}
+
+	if info, statErr := os.Stat(out); statErr == nil && info.IsDir() {
+		out = filepath.Join(out, defaultName)
+	}
+
+	base := strings.TrimSuffix(strings.TrimSuffix(out, archiveExt), descriptorExt)
+
+	return base + archiveExt, base + descriptorExt, nil
+}
+
+func writeArchive(archivePath, kernel, initramfs, cmdline string) error {
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("ospkg: create archive: %w", err)
+	}
+	defer archive.Close()
+
+	w := zip.NewWriter(archive)
+
+	if err := addFile(w, kernelEntry, kernel); err != nil {
+		return err
+	}
+
+	if initramfs != "" {
+		if err := addFile(w, initramfsEntry, initramfs); err != nil {
+			return err
+		}
+	}
+
+	if err := addBytes(w, cmdlineEntry, []byte(cmdline)); err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+func addFile(w *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("ospkg: open %s: %w", path, err)
+	}
+	defer src.Close()
+
+	dst, err := w.Create(name)
+	if err != nil {
+		return fmt.Errorf("ospkg: add %s to archive: %w", name, err)
+	}
+
+	_, err = io.Copy(dst, src)
+
+	return err
+}
+
+func addBytes(w *zip.Writer, name string, content []byte) error {
+	dst, err := w.Create(name)
+	if err != nil {
+		return fmt.Errorf("ospkg: add %s to archive: %w", name, err)
+	}
+
+	_, err = dst.Write(content)
+
+	return err
+}
+
+func writeDescriptor(path string, d *Descriptor) error {
+	jsonBytes, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		return fmt.Errorf("ospkg: marshal descriptor: %w", err)
+	}
+
+	return os.WriteFile(path, jsonBytes, 0o644)
+}
+
+// Paths exposes the archive/descriptor path derivation used by Run to other
+// ospkg commands (sign, show, verify) so they agree on the same layout.
+func Paths(ospkgPath string) (archivePath, descriptorPath string, err error) {
+	return paths(ospkgPath)
+}
+
+// LoadDescriptor reads and parses the descriptor JSON at path.
+func LoadDescriptor(path string) (*Descriptor, error) {
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ospkg: read descriptor: %w", err)
+	}
+
+	var d Descriptor
+	if err := json.Unmarshal(jsonBytes, &d); err != nil {
+		return nil, fmt.Errorf("ospkg: parse descriptor: %w", err)
+	}
+
+	return &d, nil
+}
+
+// ArchiveHash returns the SHA-256 hash of the archive at path.
+func ArchiveHash(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ospkg: open archive: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("ospkg: hash archive: %w", err)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// SigningDigest returns the canonical bytes an OS package signature is
+// computed over: SHA256(signingDomain || SHA256(archive)). Binding the
+// archive hash to a domain separator keeps an OS package signature from
+// being replayed as a signature over an unrelated SHA-256 digest.
+func SigningDigest(archivePath string) ([]byte, error) {
+	archiveHash, err := ArchiveHash(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(signingDomain))
+	h.Write(archiveHash)
+
+	return h.Sum(nil), nil
+}
+
+// Signer is the subset of kms.Signer that ospkg needs to sign an archive,
+// declared here so that ospkg does not import kms.
+type Signer interface {
+	Sign(digest []byte) ([]byte, error)
+}
+
+// Sign computes the archive's signing digest, signs it with s, and appends
+// the resulting Signature (tagged with signerID) to the descriptor at
+// descriptorPath. Existing signatures, e.g. from other signers in an M-of-N
+// scheme, are preserved. Signing with a certificate that already has a
+// signature on the descriptor is rejected, since it would let a single
+// signer be double-counted towards a threshold.
+func Sign(archivePath, descriptorPath string, s Signer, cert *x509.Certificate, signerID string) error {
+	sig, err := sign(archivePath, s, cert, signerID)
+	if err != nil {
+		return err
+	}
+
+	d, err := LoadDescriptor(descriptorPath)
+	if err != nil {
+		return err
+	}
+
+	if hasCertificate(d, sig.Certificate) {
+		return fmt.Errorf("ospkg: descriptor already has a signature from this certificate")
+	}
+
+	d.Signatures = append(d.Signatures, *sig)
+
+	return writeDescriptor(descriptorPath, d)
+}
+
+// Detach produces a Signature fragment without touching any descriptor, for
+// air-gapped signers who sign offline and hand the fragment to "ospkg sign
+// -merge" later.
+func Detach(archivePath string, s Signer, cert *x509.Certificate, signerID string) (*Signature, error) {
+	return sign(archivePath, s, cert, signerID)
+}
+
+func sign(archivePath string, s Signer, cert *x509.Certificate, signerID string) (*Signature, error) {
+	digest, err := SigningDigest(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := s.Sign(digest)
+	if err != nil {
+		return nil, fmt.Errorf("ospkg: sign archive: %w", err)
+	}
+
+	return &Signature{SignerID: signerID, Certificate: cert.Raw, Signature: sig}, nil
+}
+
+// Merge appends signature fragments produced by Detach (e.g. loaded from
+// "ospkg sign -merge sig1.json sig2.json") to the descriptor at
+// descriptorPath. A fragment whose certificate already has a signature on
+// the descriptor, or that duplicates an earlier fragment, is rejected for
+// the same reason Sign rejects it.
+func Merge(descriptorPath string, fragments ...*Signature) error {
+	d, err := LoadDescriptor(descriptorPath)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fragments {
+		if hasCertificate(d, f.Certificate) {
+			return fmt.Errorf("ospkg: descriptor already has a signature from this certificate")
+		}
+
+		d.Signatures = append(d.Signatures, *f)
+	}
+
+	return writeDescriptor(descriptorPath, d)
+}
+
+// hasCertificate reports whether d already carries a signature from the
+// same certificate, identifying a signer by its DER bytes rather than its
+// (optional, caller-chosen) SignerID.
+func hasCertificate(d *Descriptor, cert []byte) bool {
+	for _, sig := range d.Signatures {
+		if bytes.Equal(sig.Certificate, cert) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LoadSignature reads a detached Signature fragment written by "ospkg sign
+// -detach -out <path>".
+func LoadSignature(path string) (*Signature, error) {
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ospkg: read signature fragment: %w", err)
+	}
+
+	var sig Signature
+	if err := json.Unmarshal(jsonBytes, &sig); err != nil {
+		return nil, fmt.Errorf("ospkg: parse signature fragment: %w", err)
+	}
+
+	return &sig, nil
+}
+
+// SaveSignature writes a detached Signature fragment to path.
+func SaveSignature(path string, sig *Signature) error {
+	jsonBytes, err := json.MarshalIndent(sig, "", "\t")
+	if err != nil {
+		return fmt.Errorf("ospkg: marshal signature fragment: %w", err)
+	}
+
+	return os.WriteFile(path, jsonBytes, 0o644)
+}
+
+// AttachTransparency stores a transparency log entry in the descriptor at
+// descriptorPath, e.g. after submitting the signature to Rekor.
+func AttachTransparency(descriptorPath string, entry *rekor.Entry) error {
+	d, err := LoadDescriptor(descriptorPath)
+	if err != nil {
+		return err
+	}
+
+	d.Transparency = entry
+
+	return writeDescriptor(descriptorPath, d)
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ospkg
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// stubSigner is a Signer that returns a fixed signature, used to exercise
+// the multi-signature append/detach/merge plumbing without real keys.
+type stubSigner struct {
+	sig []byte
+}
+
+func (s *stubSigner) Sign(digest []byte) ([]byte, error) {
+	return s.sig, nil
+}
+
+func newTestPackage(t *testing.T) (archivePath, descriptorPath string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	kernel := filepath.Join(dir, "kernel")
+	if err := os.WriteFile(kernel, []byte("kernel-bytes"), 0o644); err != nil {
+		t.Fatalf("write kernel: %v", err)
+	}
+
+	out := filepath.Join(dir, "pkg")
+	if err := Run(out, "", "", kernel, "", ""); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	archivePath, descriptorPath, err := Paths(out)
+	if err != nil {
+		t.Fatalf("Paths() = %v", err)
+	}
+
+	return archivePath, descriptorPath
+}
+
+func TestSignAppendsRatherThanOverwrites(t *testing.T) {
+	archivePath, descriptorPath := newTestPackage(t)
+
+	certA := &x509.Certificate{Raw: []byte("cert-a")}
+	certB := &x509.Certificate{Raw: []byte("cert-b")}
+
+	if err := Sign(archivePath, descriptorPath, &stubSigner{sig: []byte("sig-a")}, certA, "signer-a"); err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	if err := Sign(archivePath, descriptorPath, &stubSigner{sig: []byte("sig-b")}, certB, "signer-b"); err != nil {
+		t.Fatalf("Sign() second signer = %v", err)
+	}
+
+	d, err := LoadDescriptor(descriptorPath)
+	if err != nil {
+		t.Fatalf("LoadDescriptor() = %v", err)
+	}
+
+	if len(d.Signatures) != 2 {
+		t.Fatalf("len(Signatures) = %d, want 2", len(d.Signatures))
+	}
+
+	if d.Signatures[0].SignerID != "signer-a" || d.Signatures[1].SignerID != "signer-b" {
+		t.Fatalf("Signatures = %+v, want signer-a then signer-b", d.Signatures)
+	}
+}
+
+func TestDetachAndMerge(t *testing.T) {
+	archivePath, descriptorPath := newTestPackage(t)
+	cert := &x509.Certificate{Raw: []byte("cert-a")}
+
+	sig, err := Detach(archivePath, &stubSigner{sig: []byte("sig-a")}, cert, "signer-a")
+	if err != nil {
+		t.Fatalf("Detach() = %v", err)
+	}
+
+	d, err := LoadDescriptor(descriptorPath)
+	if err != nil {
+		t.Fatalf("LoadDescriptor() = %v", err)
+	}
+
+	if len(d.Signatures) != 0 {
+		t.Fatalf("Detach() should not touch the descriptor, got %d signatures", len(d.Signatures))
+	}
+
+	fragmentPath := filepath.Join(t.TempDir(), "sig.json")
+	if err := SaveSignature(fragmentPath, sig); err != nil {
+		t.Fatalf("SaveSignature() = %v", err)
+	}
+
+	loaded, err := LoadSignature(fragmentPath)
+	if err != nil {
+		t.Fatalf("LoadSignature() = %v", err)
+	}
+
+	if err := Merge(descriptorPath, loaded); err != nil {
+		t.Fatalf("Merge() = %v", err)
+	}
+
+	d, err = LoadDescriptor(descriptorPath)
+	if err != nil {
+		t.Fatalf("LoadDescriptor() after Merge = %v", err)
+	}
+
+	if len(d.Signatures) != 1 || d.Signatures[0].SignerID != "signer-a" {
+		t.Fatalf("Signatures after Merge = %+v, want one signature from signer-a", d.Signatures)
+	}
+}
+
+// TestSignRejectsDuplicateCertificate guards against a single signer's
+// signature being counted twice towards an M-of-N threshold, e.g. by
+// running "ospkg sign" twice with the same key.
+func TestSignRejectsDuplicateCertificate(t *testing.T) {
+	archivePath, descriptorPath := newTestPackage(t)
+	cert := &x509.Certificate{Raw: []byte("cert-a")}
+
+	if err := Sign(archivePath, descriptorPath, &stubSigner{sig: []byte("sig-a")}, cert, "signer-a"); err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	if err := Sign(archivePath, descriptorPath, &stubSigner{sig: []byte("sig-a-again")}, cert, "signer-a"); err == nil {
+		t.Fatal("Sign() with an already-used certificate succeeded, want an error")
+	}
+
+	d, err := LoadDescriptor(descriptorPath)
+	if err != nil {
+		t.Fatalf("LoadDescriptor() = %v", err)
+	}
+
+	if len(d.Signatures) != 1 {
+		t.Fatalf("len(Signatures) = %d, want 1 after the rejected duplicate", len(d.Signatures))
+	}
+}
+
+// TestMergeRejectsDuplicateCertificate covers the same guarantee for
+// "ospkg sign -merge", e.g. merging the same detached fragment onto itself.
+func TestMergeRejectsDuplicateCertificate(t *testing.T) {
+	archivePath, descriptorPath := newTestPackage(t)
+	cert := &x509.Certificate{Raw: []byte("cert-a")}
+
+	sig, err := Detach(archivePath, &stubSigner{sig: []byte("sig-a")}, cert, "signer-a")
+	if err != nil {
+		t.Fatalf("Detach() = %v", err)
+	}
+
+	if err := Merge(descriptorPath, sig, sig); err == nil {
+		t.Fatal("Merge() with a duplicated fragment succeeded, want an error")
+	}
+
+	d, err := LoadDescriptor(descriptorPath)
+	if err != nil {
+		t.Fatalf("LoadDescriptor() = %v", err)
+	}
+
+	if len(d.Signatures) != 0 {
+		t.Fatalf("len(Signatures) = %d, want 0 after a rejected Merge", len(d.Signatures))
+	}
+}
+
+// TestReportValidDedupesByCertificate guards against a single signer's
+// signature being counted twice towards a threshold, e.g. from a
+// descriptor that carries the same certificate's signature more than once.
+func TestReportValidDedupesByCertificate(t *testing.T) {
+	report := &Report{
+		Signatures: []SignatureReport{
+			{KeyID: "same-key", HashMatches: true},
+			{KeyID: "same-key", HashMatches: true},
+			{KeyID: "other-key", HashMatches: true},
+		},
+	}
+
+	if got := report.Valid(); got != 2 {
+		t.Fatalf("Valid() = %d, want 2 for two distinct signers", got)
+	}
+}
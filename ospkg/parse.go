@@ -0,0 +1,212 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ospkg
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Package bundles a parsed descriptor with the paths it was loaded from, so
+// that callers like "ospkg show" and "ospkg verify" don't have to re-derive
+// the archive/descriptor layout themselves.
+type Package struct {
+	Descriptor     *Descriptor
+	ArchivePath    string
+	DescriptorPath string
+}
+
+// Parse loads the descriptor for the OS package at ospkgPath (an archive or
+// descriptor path, see Paths) without touching the signature.
+func Parse(ospkgPath string) (*Package, error) {
+	archivePath, descriptorPath, err := Paths(ospkgPath)
+	if err != nil {
+		return nil, fmt.Errorf("ospkg: %w", err)
+	}
+
+	d, err := LoadDescriptor(descriptorPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Package{Descriptor: d, ArchivePath: archivePath, DescriptorPath: descriptorPath}, nil
+}
+
+// SignatureReport is what "ospkg show" prints for one of a package's
+// signatures.
+type SignatureReport struct {
+	SignerID     string    `json:"signer_id,omitempty"`
+	CommonName   string    `json:"common_name"`
+	KeyID        string    `json:"key_id"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	ChainsToRoot bool      `json:"chains_to_root"`
+	HashMatches  bool      `json:"hash_matches"`
+}
+
+// Report is the full inspection result for "ospkg show".
+type Report struct {
+	KernelHash    string            `json:"kernel_sha256"`
+	InitramfsHash string            `json:"initramfs_sha256,omitempty"`
+	Cmdline       string            `json:"cmdline"`
+	Label         string            `json:"label"`
+	URL           string            `json:"url,omitempty"`
+	Signatures    []SignatureReport `json:"signatures,omitempty"`
+}
+
+// Valid returns how many distinct signers (by certificate) have a
+// signature that matches the archive hash. A certificate repeated across
+// multiple signatures, e.g. from merging a fragment onto itself, counts
+// once. "ospkg verify -threshold N" does not call Valid: verify.Package
+// does its own per-certificate count.
+func (r *Report) Valid() int {
+	seen := make(map[string]bool)
+	n := 0
+
+	for _, s := range r.Signatures {
+		if !s.HashMatches || seen[s.KeyID] {
+			continue
+		}
+
+		seen[s.KeyID] = true
+		n++
+	}
+
+	return n
+}
+
+// Verify builds the inspection Report for p, checking every signature
+// against rootPEM if given (an empty rootPEM leaves ChainsToRoot false).
+func (p *Package) Verify(rootPEM string) (*Report, error) {
+	kernelHash, err := EntryHash(p.ArchivePath, kernelEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		KernelHash: hex.EncodeToString(kernelHash),
+		Cmdline:    p.Descriptor.Cmdline,
+		Label:      p.Descriptor.Label,
+		URL:        p.Descriptor.URL,
+	}
+
+	if initramfsHash, err := EntryHash(p.ArchivePath, initramfsEntry); err == nil {
+		report.InitramfsHash = hex.EncodeToString(initramfsHash)
+	}
+
+	digest, err := SigningDigest(p.ArchivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sig := range p.Descriptor.Signatures {
+		sigReport, err := signatureReport(sig, digest, rootPEM)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Signatures = append(report.Signatures, *sigReport)
+	}
+
+	return report, nil
+}
+
+func signatureReport(sig Signature, digest []byte, rootPEM string) (*SignatureReport, error) {
+	cert, err := x509.ParseCertificate(sig.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("ospkg: parse signer certificate: %w", err)
+	}
+
+	keyID := sha256.Sum256(cert.Raw)
+
+	report := &SignatureReport{
+		SignerID:    sig.SignerID,
+		CommonName:  cert.Subject.CommonName,
+		KeyID:       hex.EncodeToString(keyID[:]),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		HashMatches: VerifySignature(cert, digest, sig.Signature),
+	}
+
+	if rootPEM != "" {
+		trusted, err := ChainsToRoot(cert, rootPEM)
+		if err != nil {
+			return nil, err
+		}
+
+		report.ChainsToRoot = trusted
+	}
+
+	return report, nil
+}
+
+// VerifySignature reports whether signature is a valid ED25519 signature by
+// cert over hash. It is shared by ospkg's own reports and by package verify,
+// so the two don't carry independent, driftable copies of the same check.
+func VerifySignature(cert *x509.Certificate, hash, signature []byte) bool {
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return false
+	}
+
+	return ed25519.Verify(pub, hash, signature)
+}
+
+// ChainsToRoot reports whether cert chains to one of the certificates in
+// rootPEM. It is shared by ospkg's own reports and by package verify, so the
+// two don't carry independent, driftable copies of the same check.
+func ChainsToRoot(cert *x509.Certificate, rootPEM string) (bool, error) {
+	pemBytes, err := os.ReadFile(rootPEM)
+	if err != nil {
+		return false, fmt.Errorf("ospkg: read root certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return false, fmt.Errorf("ospkg: no certificates found in %s", rootPEM)
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+
+	return err == nil, nil
+}
+
+// EntryHash returns the SHA-256 hash of the named file inside the OS
+// package archive at archivePath.
+func EntryHash(archivePath, name string) ([]byte, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("ospkg: open archive: %w", err)
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("ospkg: open %s in archive: %w", name, err)
+		}
+		defer rc.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, rc); err != nil {
+			return nil, fmt.Errorf("ospkg: hash %s: %w", name, err)
+		}
+
+		return h.Sum(nil), nil
+	}
+
+	return nil, fmt.Errorf("ospkg: %s not found in archive", name)
+}
@@ -0,0 +1,250 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ospkg
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ed25519TestSigner is a Signer backed by a real ED25519 private key, so
+// that signatures produced in tests verify the same way a real signer's
+// would.
+type ed25519TestSigner ed25519.PrivateKey
+
+func (s ed25519TestSigner) Sign(digest []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(s), digest), nil
+}
+
+func selfSignedTestCert(t *testing.T, pub ed25519.PublicKey, priv ed25519.PrivateKey) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	return cert
+}
+
+// writeTestRootPEM PEM-encodes cert and writes it to a temp file, for use as
+// the -root given to Verify.
+func writeTestRootPEM(t *testing.T, cert *x509.Certificate) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "root.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+	if err := os.WriteFile(path, pemBytes, 0o644); err != nil {
+		t.Fatalf("write root certificate: %v", err)
+	}
+
+	return path
+}
+
+func TestParseAndVerifySignedPackage(t *testing.T) {
+	archivePath, descriptorPath := newTestPackage(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signer key: %v", err)
+	}
+
+	cert := selfSignedTestCert(t, pub, priv)
+
+	if err := Sign(archivePath, descriptorPath, ed25519TestSigner(priv), cert, "release-manager"); err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	p, err := Parse(archivePath)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+
+	report, err := p.Verify(writeTestRootPEM(t, cert))
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	if len(report.Signatures) != 1 || !report.Signatures[0].HashMatches {
+		t.Fatalf("Signatures = %+v, want one signature with HashMatches = true", report.Signatures)
+	}
+
+	if !report.Signatures[0].ChainsToRoot {
+		t.Fatal("Signatures[0].ChainsToRoot = false, want true for the signer's own self-signed root")
+	}
+
+	if got := report.Valid(); got != 1 {
+		t.Fatalf("Valid() = %d, want 1", got)
+	}
+}
+
+// TestVerifyDetectsTamperedArchive guards against Verify trusting a
+// signature whose archive has been modified after signing.
+func TestVerifyDetectsTamperedArchive(t *testing.T) {
+	archivePath, descriptorPath := newTestPackage(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signer key: %v", err)
+	}
+
+	cert := selfSignedTestCert(t, pub, priv)
+
+	if err := Sign(archivePath, descriptorPath, ed25519TestSigner(priv), cert, "release-manager"); err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	f, err := os.OpenFile(archivePath, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("open archive for tampering: %v", err)
+	}
+
+	if _, err := f.Write([]byte("tampered")); err != nil {
+		t.Fatalf("tamper with archive: %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("close tampered archive: %v", err)
+	}
+
+	p, err := Parse(archivePath)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+
+	report, err := p.Verify("")
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	if report.Signatures[0].HashMatches {
+		t.Fatal("Signatures[0].HashMatches = true, want false for a tampered archive")
+	}
+
+	if got := report.Valid(); got != 0 {
+		t.Fatalf("Valid() = %d, want 0 for a tampered archive", got)
+	}
+}
+
+// TestVerifyUntrustedCertificate guards against ChainsToRoot reporting true
+// for a signer certificate that isn't actually issued by the given root.
+func TestVerifyUntrustedCertificate(t *testing.T) {
+	archivePath, descriptorPath := newTestPackage(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signer key: %v", err)
+	}
+
+	cert := selfSignedTestCert(t, pub, priv)
+
+	if err := Sign(archivePath, descriptorPath, ed25519TestSigner(priv), cert, "release-manager"); err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	otherPub, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate unrelated root key: %v", err)
+	}
+
+	unrelatedRoot := selfSignedTestCert(t, otherPub, otherPriv)
+
+	p, err := Parse(archivePath)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+
+	report, err := p.Verify(writeTestRootPEM(t, unrelatedRoot))
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	if !report.Signatures[0].HashMatches {
+		t.Fatal("Signatures[0].HashMatches = false, want true: the signature itself is still valid")
+	}
+
+	if report.Signatures[0].ChainsToRoot {
+		t.Fatal("Signatures[0].ChainsToRoot = true, want false for a root that didn't issue the signer certificate")
+	}
+}
+
+// TestVerifyDedupesMergedDuplicateSignature guards against a single signer
+// being counted twice towards Valid(), e.g. from a descriptor that (however
+// it was produced) carries the same certificate's signature more than once.
+// Sign and Merge already refuse to create such a descriptor, but Verify must
+// not trust a duplicate that reaches it by some other means.
+func TestVerifyDedupesMergedDuplicateSignature(t *testing.T) {
+	archivePath, descriptorPath := newTestPackage(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signer key: %v", err)
+	}
+
+	cert := selfSignedTestCert(t, pub, priv)
+
+	if err := Sign(archivePath, descriptorPath, ed25519TestSigner(priv), cert, "release-manager"); err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	d, err := LoadDescriptor(descriptorPath)
+	if err != nil {
+		t.Fatalf("LoadDescriptor() = %v", err)
+	}
+
+	// Duplicate the one real signature directly in the descriptor, bypassing
+	// Sign/Merge's own duplicate-certificate check, the way a hand-edited or
+	// pre-existing descriptor could.
+	d.Signatures = append(d.Signatures, d.Signatures[0])
+
+	jsonBytes, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		t.Fatalf("marshal descriptor: %v", err)
+	}
+
+	if err := os.WriteFile(descriptorPath, jsonBytes, 0o644); err != nil {
+		t.Fatalf("write descriptor: %v", err)
+	}
+
+	p, err := Parse(archivePath)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+
+	report, err := p.Verify("")
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	if len(report.Signatures) != 2 {
+		t.Fatalf("len(Signatures) = %d, want 2", len(report.Signatures))
+	}
+
+	if got := report.Valid(); got != 1 {
+		t.Fatalf("Valid() = %d, want 1 for two signatures from the same certificate", got)
+	}
+}
@@ -37,22 +37,38 @@ type HostCfgSimplified struct {
 // on the efi bool either writes it to disk as "host_configuration.json"
 // in the current directory or into the efivarfs.
 func MarshalCfg(cfg *HostCfgSimplified, efi bool) error {
+	if efi {
+		return marshalCfgEfi(cfg)
+	}
+
+	return MarshalCfgToFile(cfg, "host_configuration.json")
+}
+
+// MarshalCfgToFile writes cfg as JSON to path, for callers (like "stmgr
+// onboard") that manage their own output layout instead of the default
+// "host_configuration.json" in the current directory.
+func MarshalCfgToFile(cfg *HostCfgSimplified, path string) error {
 	jsonBytes, err := json.Marshal(cfg)
 	if err != nil {
 		return err
 	}
 
-	if efi {
-		name := "STHostConfig-f401f2c1-b005-4be0-8cee-f2e5945bcbe7"
-		attrs := efivarfs.AttributeBootserviceAccess | efivarfs.AttributeRuntimeAccess | efivarfs.AttributeNonVolatile
+	return os.WriteFile(path, jsonBytes, defaultFilePerm)
+}
 
-		e, err := efivarfs.New()
-		if err != nil {
-			return err
-		}
+func marshalCfgEfi(cfg *HostCfgSimplified) error {
+	jsonBytes, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
 
-		return efivarfs.SimpleWriteVariable(e, name, attrs, bytes.NewBuffer(jsonBytes))
+	name := "STHostConfig-f401f2c1-b005-4be0-8cee-f2e5945bcbe7"
+	attrs := efivarfs.AttributeBootserviceAccess | efivarfs.AttributeRuntimeAccess | efivarfs.AttributeNonVolatile
+
+	e, err := efivarfs.New()
+	if err != nil {
+		return err
 	}
 
-	return os.WriteFile("host_configuration.json", jsonBytes, defaultFilePerm)
+	return efivarfs.SimpleWriteVariable(e, name, attrs, bytes.NewBuffer(jsonBytes))
 }
@@ -0,0 +1,191 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rekor
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// referenceRoot and referencePath are an independent, naive implementation
+// of the RFC 6962 Merkle Tree Hash and Merkle Audit Path algorithms (see
+// section 2.1), used to check RootFromInclusionProof against a reference
+// rather than against itself.
+func referenceRoot(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		sum := HashLeaf(leaves[0])
+
+		return sum[:]
+	}
+
+	k := largestPowerOfTwoBelow(len(leaves))
+
+	return hashChildren(referenceRoot(leaves[:k]), referenceRoot(leaves[k:]))
+}
+
+func referencePath(index int, leaves [][]byte) [][]byte {
+	if len(leaves) == 1 {
+		return nil
+	}
+
+	k := largestPowerOfTwoBelow(len(leaves))
+
+	if index < k {
+		return append(referencePath(index, leaves[:k]), referenceRoot(leaves[k:]))
+	}
+
+	return append(referencePath(index-k, leaves[k:]), referenceRoot(leaves[:k]))
+}
+
+func largestPowerOfTwoBelow(n int) int {
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+
+	return k
+}
+
+func TestRootFromInclusionProofAgainstReference(t *testing.T) {
+	leaves := [][]byte{
+		[]byte("leaf-0"), []byte("leaf-1"), []byte("leaf-2"),
+		[]byte("leaf-3"), []byte("leaf-4"),
+	}
+
+	wantRoot := referenceRoot(leaves)
+
+	for index := range leaves {
+		proof := referencePath(index, leaves)
+
+		leafHash := HashLeaf(leaves[index])
+
+		gotRoot, err := RootFromInclusionProof(int64(index), int64(len(leaves)), proof, leafHash[:])
+		if err != nil {
+			t.Fatalf("RootFromInclusionProof(%d) = %v", index, err)
+		}
+
+		if !bytes.Equal(gotRoot, wantRoot) {
+			t.Fatalf("RootFromInclusionProof(%d) = %x, want %x", index, gotRoot, wantRoot)
+		}
+	}
+}
+
+func TestRootFromInclusionProofSingleLeaf(t *testing.T) {
+	leafHash := HashLeaf([]byte("only-leaf"))
+
+	root, err := RootFromInclusionProof(0, 1, nil, leafHash[:])
+	if err != nil {
+		t.Fatalf("RootFromInclusionProof() = %v", err)
+	}
+
+	if !bytes.Equal(root, leafHash[:]) {
+		t.Fatalf("RootFromInclusionProof() = %x, want the leaf hash %x unchanged", root, leafHash)
+	}
+}
+
+func TestRootFromInclusionProofRejectsWrongProofLength(t *testing.T) {
+	leaves := [][]byte{[]byte("leaf-0"), []byte("leaf-1"), []byte("leaf-2")}
+	leafHash := HashLeaf(leaves[0])
+
+	if _, err := RootFromInclusionProof(0, int64(len(leaves)), nil, leafHash[:]); err == nil {
+		t.Fatal("RootFromInclusionProof() with a missing audit path hash succeeded, want an error")
+	}
+
+	proof := referencePath(0, leaves)
+	proof = append(proof, []byte("unexpected-extra-hash"))
+
+	if _, err := RootFromInclusionProof(0, int64(len(leaves)), proof, leafHash[:]); err == nil {
+		t.Fatal("RootFromInclusionProof() with an extra audit path hash succeeded, want an error")
+	}
+}
+
+func TestRootFromInclusionProofRejectsOutOfRangeIndex(t *testing.T) {
+	leafHash := HashLeaf([]byte("leaf"))
+
+	if _, err := RootFromInclusionProof(2, 2, nil, leafHash[:]); err == nil {
+		t.Fatal("RootFromInclusionProof() with leafIndex == treeSize succeeded, want an error")
+	}
+}
+
+// TestSubmitPostsTheCanonicalEntryAndDecodesTheResponse guards the actual
+// HTTP submission path: that Submit POSTs to /api/v1/log/entries with the
+// same bytes CanonicalJSON produces, and decodes a successful response into
+// an Entry.
+func TestSubmitPostsTheCanonicalEntryAndDecodesTheResponse(t *testing.T) {
+	archiveHash := []byte("archive-hash")
+	signature := []byte("signature")
+	certDER := []byte("cert")
+
+	wantBody, err := CanonicalJSON(archiveHash, signature, certDER)
+	if err != nil {
+		t.Fatalf("CanonicalJSON() = %v", err)
+	}
+
+	wantEntry := &Entry{
+		LogIndex:             1,
+		LogID:                "test-log",
+		IntegratedTime:       100,
+		SignedEntryTimestamp: []byte("set"),
+	}
+	wantEntry.InclusionProof.LogIndex = 0
+	wantEntry.InclusionProof.TreeSize = 1
+	wantEntry.InclusionProof.RootHash = "root"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("request method = %s, want POST", r.Method)
+		}
+
+		if r.URL.Path != "/api/v1/log/entries" {
+			t.Errorf("request path = %s, want /api/v1/log/entries", r.URL.Path)
+		}
+
+		gotBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("read request body: %v", err)
+		}
+
+		if !bytes.Equal(gotBody, wantBody) {
+			t.Errorf("request body = %s, want %s", gotBody, wantBody)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(wantEntry); err != nil {
+			t.Fatalf("encode response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	got, err := NewClient(server.URL).Submit(archiveHash, signature, certDER)
+	if err != nil {
+		t.Fatalf("Submit() = %v", err)
+	}
+
+	if got.LogIndex != wantEntry.LogIndex || got.LogID != wantEntry.LogID || got.IntegratedTime != wantEntry.IntegratedTime {
+		t.Fatalf("Submit() = %+v, want %+v", got, wantEntry)
+	}
+
+	if got.InclusionProof.RootHash != wantEntry.InclusionProof.RootHash {
+		t.Fatalf("Submit().InclusionProof = %+v, want %+v", got.InclusionProof, wantEntry.InclusionProof)
+	}
+}
+
+// TestSubmitRejectsNonSuccessStatus guards against Submit treating a
+// non-2xx response, e.g. a log rejecting a malformed entry, as success.
+func TestSubmitRejectsNonSuccessStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad entry", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(server.URL).Submit([]byte("hash"), []byte("sig"), []byte("cert")); err == nil {
+		t.Fatal("Submit() with a 400 response succeeded, want an error")
+	}
+}
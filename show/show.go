@@ -0,0 +1,139 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package show implements the "ospkg show" subcommand: printing an OS
+// package's metadata and signature status for human or CI consumption.
+package show
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/system-transparency/stmgr/ospkg"
+)
+
+// Exit codes for "ospkg show", distinguishing why a package failed
+// verification so CI can branch on it instead of parsing text output.
+const (
+	ExitOK               = 0
+	ExitUnsigned         = 2
+	ExitSignatureInvalid = 3
+	ExitCertNotTrusted   = 4
+)
+
+// VerificationError is returned by Run when the package does not verify. It
+// carries the exit code main() should use.
+type VerificationError struct {
+	Reason string
+	Code   int
+}
+
+func (e *VerificationError) Error() string {
+	return e.Reason
+}
+
+// ExitCode implements the interface main() checks to pick a process exit
+// code distinct from the generic "1" used for unexpected errors.
+func (e *VerificationError) ExitCode() int {
+	return e.Code
+}
+
+// Run parses and prints the OS package at ospkgPath, verifying its
+// signature against rootCert (may be empty). When jsonOutput is set, a
+// single JSON report is printed instead of the human readable text. Run
+// returns a *VerificationError when the package is unsigned, its signature
+// is invalid, or its certificate does not chain to rootCert.
+func Run(ospkgPath, rootCert string, jsonOutput bool) error {
+	pkg, err := ospkg.Parse(ospkgPath)
+	if err != nil {
+		return err
+	}
+
+	report, err := pkg.Verify(rootCert)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		jsonBytes, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return fmt.Errorf("show: marshal report: %w", err)
+		}
+
+		fmt.Fprintln(os.Stdout, string(jsonBytes))
+	} else {
+		printReport(report)
+	}
+
+	return verdict(report, rootCert)
+}
+
+func printReport(r *ospkg.Report) {
+	fmt.Printf("label:           %s\n", r.Label)
+	fmt.Printf("cmdline:         %s\n", r.Cmdline)
+	fmt.Printf("kernel sha256:   %s\n", r.KernelHash)
+
+	if r.InitramfsHash != "" {
+		fmt.Printf("initramfs sha256: %s\n", r.InitramfsHash)
+	}
+
+	if r.URL != "" {
+		fmt.Printf("url:             %s\n", r.URL)
+	}
+
+	if len(r.Signatures) == 0 {
+		fmt.Println("signatures:      none (unsigned)")
+
+		return
+	}
+
+	for i, s := range r.Signatures {
+		fmt.Printf("signature %d:\n", i)
+
+		if s.SignerID != "" {
+			fmt.Printf("  signer ID:     %s\n", s.SignerID)
+		}
+
+		fmt.Printf("  signer CN:     %s\n", s.CommonName)
+		fmt.Printf("  signer key ID: %s\n", s.KeyID)
+		fmt.Printf("  valid:         %s - %s\n", s.NotBefore.Format(time.RFC3339), s.NotAfter.Format(time.RFC3339))
+		fmt.Printf("  hash matches:  %v\n", s.HashMatches)
+		fmt.Printf("  chains to root: %v\n", s.ChainsToRoot)
+	}
+}
+
+// verdict turns a Report into the distinct unsigned/invalid/untrusted/ok
+// outcomes callers need. A package counts as ok as soon as at least one
+// signature both matches the archive hash and, when rootCert is given,
+// chains to it; "ospkg verify -threshold" is where a higher bar is enforced.
+func verdict(r *ospkg.Report, rootCert string) error {
+	if len(r.Signatures) == 0 {
+		return &VerificationError{Reason: "ospkg is unsigned", Code: ExitUnsigned}
+	}
+
+	validHash := false
+	trusted := false
+
+	for _, s := range r.Signatures {
+		if s.HashMatches {
+			validHash = true
+
+			if s.ChainsToRoot {
+				trusted = true
+			}
+		}
+	}
+
+	if !validHash {
+		return &VerificationError{Reason: "ospkg signature is invalid", Code: ExitSignatureInvalid}
+	}
+
+	if rootCert != "" && !trusted {
+		return &VerificationError{Reason: "ospkg signer certificate is not trusted", Code: ExitCertNotTrusted}
+	}
+
+	return nil
+}
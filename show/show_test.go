@@ -0,0 +1,53 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package show
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/system-transparency/stmgr/ospkg"
+)
+
+func TestVerdictUnsigned(t *testing.T) {
+	err := verdict(&ospkg.Report{}, "")
+	if err == nil {
+		t.Fatal("verdict() on an unsigned package should return an error")
+	}
+
+	var verr *VerificationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("verdict() error = %v, want a *VerificationError", err)
+	}
+
+	if verr.ExitCode() != ExitUnsigned {
+		t.Fatalf("verdict() exit code = %d, want %d", verr.ExitCode(), ExitUnsigned)
+	}
+}
+
+func TestVerdictSignatureInvalid(t *testing.T) {
+	err := verdict(&ospkg.Report{Signatures: []ospkg.SignatureReport{{HashMatches: false}}}, "")
+
+	var verr *VerificationError
+	if !errors.As(err, &verr) || verr.ExitCode() != ExitSignatureInvalid {
+		t.Fatalf("verdict() error = %v, want ExitSignatureInvalid", err)
+	}
+}
+
+func TestVerdictCertNotTrusted(t *testing.T) {
+	err := verdict(&ospkg.Report{Signatures: []ospkg.SignatureReport{{HashMatches: true, ChainsToRoot: false}}}, "root.pem")
+
+	var verr *VerificationError
+	if !errors.As(err, &verr) || verr.ExitCode() != ExitCertNotTrusted {
+		t.Fatalf("verdict() error = %v, want ExitCertNotTrusted", err)
+	}
+}
+
+func TestVerdictOK(t *testing.T) {
+	err := verdict(&ospkg.Report{Signatures: []ospkg.SignatureReport{{HashMatches: true, ChainsToRoot: true}}}, "root.pem")
+	if err != nil {
+		t.Fatalf("verdict() = %v, want nil for a fully valid package", err)
+	}
+}
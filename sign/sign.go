@@ -0,0 +1,173 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sign implements the "ospkg sign" subcommand: it signs the archive
+// of an OS package and stores the signature in its descriptor.
+package sign
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/system-transparency/stmgr/kms"
+	"github.com/system-transparency/stmgr/ospkg"
+	"github.com/system-transparency/stmgr/rekor"
+	"github.com/system-transparency/stmgr/verify"
+)
+
+// Options configures a single "ospkg sign" invocation.
+type Options struct {
+	Key      string // key URI, see kms.New
+	Cert     string // PEM certificate, optional if the key manager has one
+	OSPKG    string // OS package archive or descriptor path
+	SignerID string // identifies this signature in an M-of-N scheme
+
+	Detach string   // if set, write a detached Signature fragment here instead of touching the descriptor
+	Merge  []string // detached Signature fragments to append to OSPKG's descriptor instead of signing
+
+	RekorURL       string
+	RekorPublicKey string
+}
+
+// Run signs, detaches, or merges signatures for an OS package, depending on
+// which of Options.Merge/Options.Detach is set.
+func Run(opts Options) error {
+	if len(opts.Merge) > 0 {
+		return merge(opts.OSPKG, opts.Merge)
+	}
+
+	if opts.Key == "" {
+		return fmt.Errorf("sign: -key is required")
+	}
+
+	signer, err := kms.New(opts.Key)
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+
+	signerCert, err := certificate(opts.Cert, signer)
+	if err != nil {
+		return err
+	}
+
+	archivePath, descriptorPath, err := ospkg.Paths(opts.OSPKG)
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+
+	if opts.Detach != "" {
+		sig, err := ospkg.Detach(archivePath, signer, signerCert, opts.SignerID)
+		if err != nil {
+			return err
+		}
+
+		return ospkg.SaveSignature(opts.Detach, sig)
+	}
+
+	if err := ospkg.Sign(archivePath, descriptorPath, signer, signerCert, opts.SignerID); err != nil {
+		return err
+	}
+
+	if opts.RekorURL == "" {
+		return nil
+	}
+
+	return submitToLog(archivePath, descriptorPath, opts.RekorURL, opts.RekorPublicKey)
+}
+
+// merge loads detached Signature fragments from fragmentPaths and appends
+// them to ospkgPath's descriptor, for combining signatures collected from
+// air-gapped signers.
+func merge(ospkgPath string, fragmentPaths []string) error {
+	_, descriptorPath, err := ospkg.Paths(ospkgPath)
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+
+	fragments := make([]*ospkg.Signature, 0, len(fragmentPaths))
+
+	for _, path := range fragmentPaths {
+		sig, err := ospkg.LoadSignature(path)
+		if err != nil {
+			return err
+		}
+
+		fragments = append(fragments, sig)
+	}
+
+	return ospkg.Merge(descriptorPath, fragments...)
+}
+
+// submitToLog submits a hashedrekord entry for the signature that was just
+// appended to the descriptor and embeds the log's response back into it. If
+// rekorPublicKey is given, the log's response is verified offline before
+// being trusted.
+func submitToLog(archivePath, descriptorPath, rekorURL, rekorPublicKey string) error {
+	d, err := ospkg.LoadDescriptor(descriptorPath)
+	if err != nil {
+		return err
+	}
+
+	if len(d.Signatures) == 0 {
+		return fmt.Errorf("sign: no signature to submit to the transparency log")
+	}
+
+	sig := d.Signatures[len(d.Signatures)-1]
+
+	hash, err := ospkg.ArchiveHash(archivePath)
+	if err != nil {
+		return err
+	}
+
+	entry, err := rekor.NewClient(rekorURL).Submit(hash, sig.Signature, sig.Certificate)
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+
+	if rekorPublicKey != "" {
+		ok, err := verify.VerifyInclusion(entry, hash, sig.Signature, sig.Certificate, rekorPublicKey)
+		if err != nil {
+			return fmt.Errorf("sign: %w", err)
+		}
+
+		if !ok {
+			return fmt.Errorf("sign: transparency log returned an entry that does not verify against -rekor-public-key")
+		}
+	}
+
+	return ospkg.AttachTransparency(descriptorPath, entry)
+}
+
+// certificate resolves the certificate to embed alongside the signature:
+// the -cert flag takes precedence, falling back to the key manager's own
+// certificate when set.
+func certificate(certPath string, signer kms.Signer) (*x509.Certificate, error) {
+	if certPath == "" {
+		cert, err := signer.Certificate()
+		if err != nil {
+			return nil, fmt.Errorf("sign: no -cert given and key manager has none: %w", err)
+		}
+
+		return cert, nil
+	}
+
+	pemBytes, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("sign: read cert: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("sign: no PEM block found in %s", certPath)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("sign: parse cert: %w", err)
+	}
+
+	return cert, nil
+}
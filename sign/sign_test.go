@@ -0,0 +1,56 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sign
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/system-transparency/stmgr/kms"
+)
+
+// mockSigner is a kms.Signer that never touches disk or an HSM, used to
+// exercise sign.Run's certificate resolution logic.
+type mockSigner struct {
+	cert *x509.Certificate
+	err  error
+}
+
+func (m *mockSigner) Sign(digest []byte) ([]byte, error) {
+	return []byte("mock-signature"), nil
+}
+
+func (m *mockSigner) Public() (ed25519.PublicKey, error) {
+	return nil, nil
+}
+
+func (m *mockSigner) Certificate() (*x509.Certificate, error) {
+	return m.cert, m.err
+}
+
+func TestCertificateFallsBackToSignerCertificate(t *testing.T) {
+	want := &x509.Certificate{SerialNumber: nil}
+
+	var s kms.Signer = &mockSigner{cert: want}
+
+	got, err := certificate("", s)
+	if err != nil {
+		t.Fatalf("certificate() returned error: %v", err)
+	}
+
+	if got != want {
+		t.Fatalf("certificate() = %v, want %v", got, want)
+	}
+}
+
+func TestCertificateRequiresSomeSource(t *testing.T) {
+	var s kms.Signer = &mockSigner{err: errors.New("no certificate on token")}
+
+	if _, err := certificate("", s); err == nil {
+		t.Fatal("certificate() should fail when neither -cert nor the key manager provide one")
+	}
+}
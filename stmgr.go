@@ -8,11 +8,16 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/system-transparency/stmgr/build"
 	"github.com/system-transparency/stmgr/keygen"
+	"github.com/system-transparency/stmgr/onboard"
 	"github.com/system-transparency/stmgr/ospkg"
 	"github.com/system-transparency/stmgr/provision"
+	"github.com/system-transparency/stmgr/show"
 	"github.com/system-transparency/stmgr/sign"
+	"github.com/system-transparency/stmgr/verify"
 )
 
 const (
@@ -31,7 +36,15 @@ COMMANDS:
 		system-transparency.
 
 	build:
-		Not yet implemented!
+		Set of commands to turn an OS package into a bootable
+		ISO or disk image that chainloads stboot.
+
+	onboard:
+		Guided, resumable flow that bootstraps a full signing
+		setup end-to-end: root CA, signer certificates, an OS
+		package, its signatures and a host configuration. This is
+		a stdin/stdout prompt wizard, not a terminal UI; see
+		"go doc ./onboard" for why.
 
 Use 'stmgr <COMMAND> -help' for more info.
 `
@@ -44,6 +57,13 @@ Use 'stmgr <COMMAND> -help' for more info.
 	sign:
 		Sign the provided OS package with your private key.
 
+	verify:
+		Verify the signature, trust chain and transparency log
+		inclusion of an OS package.
+
+	show:
+		Inspect an OS package's metadata and signature status.
+
 Use 'stmgr ospkg <SUBCOMMAND> -help' for more info.
 `
 
@@ -62,19 +82,85 @@ Use 'stmgr provision <SUBCOMMAND> -help' for more info.
 		using ED25519 keys.
 
 Use 'stmgr keygen <SUBCOMMAND> -help' for more info.
+`
+
+	buildUsage = `SUBCOMMANDS:
+	iso:
+		Build a hybrid BIOS/UEFI ISO image that chainloads stboot.
+
+	disk:
+		Build a raw GPT disk image usable with 'qemu -drive'.
+
+Use 'stmgr build <SUBCOMMAND> -help' for more info.
 `
 )
 
+// exitCoder is implemented by errors that want a specific process exit code
+// instead of the generic 1, e.g. show.VerificationError.
+type exitCoder interface {
+	ExitCode() int
+}
+
 func main() {
 	if err := run(os.Args); err != nil {
 		fmt.Printf("ERROR: %v\n", err)
+
+		if ec, ok := err.(exitCoder); ok {
+			os.Exit(ec.ExitCode())
+		}
+
 		os.Exit(1)
 	}
 }
 
+// runOSPKGVerify verifies the OS package at ospkgPath and prints a short
+// human readable report.
+func runOSPKGVerify(ospkgPath, root, rekorPublicKey string, threshold int) error {
+	result, err := verify.Package(ospkgPath, root, rekorPublicKey, threshold)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("signed:             %v\n", result.Signed)
+	fmt.Printf("valid signatures:   %d\n", result.ValidSignatures)
+	fmt.Printf("trusted signatures: %d\n", result.TrustedSignatures)
+	fmt.Printf("threshold met:      %v\n", result.ThresholdMet)
+	fmt.Printf("logged:             %v\n", result.LoggedOK)
+
+	if !result.Signed || !result.ThresholdMet {
+		return fmt.Errorf("ospkg verify: %s failed verification", ospkgPath)
+	}
+
+	if rekorPublicKey != "" && !result.LoggedOK {
+		return fmt.Errorf("ospkg verify: %s has no transparency log entry that verifies against %s", ospkgPath, rekorPublicKey)
+	}
+
+	return nil
+}
+
+// commaSeparated implements flag.Value for a repeatable or comma-separated
+// list flag, e.g. "-merge sig1.json,sig2.json" or "-merge sig1.json -merge sig2.json".
+type commaSeparated []string
+
+func (s *commaSeparated) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *commaSeparated) Set(value string) error {
+	*s = append(*s, strings.Split(value, ",")...)
+
+	return nil
+}
+
 func run(args []string) error {
-	// Display helptext if no arguments are given
-	if len(args) < 3 {
+	// Display helptext if no arguments are given. "onboard" is the one
+	// top-level command without a subcommand, so it only needs args[1].
+	if len(args) < 2 {
+		fmt.Print(usage)
+		return nil
+	}
+
+	if len(args) < 3 && args[1] != "onboard" {
 		fmt.Print(usage)
 		return nil
 	}
@@ -102,19 +188,56 @@ func run(args []string) error {
 		case "sign":
 			// Sign tool and flags
 			signCmd := flag.NewFlagSet("sign", flag.ExitOnError)
-			signKey := signCmd.String("key", "", "Private key for signing.")
-			signCert := signCmd.String("cert", "", "Certificate corresponding to the private key.")
+			signKey := signCmd.String("key", "", "Key URI for signing, e.g. softkms:./key.pem, pkcs11:slot-id=0;object=stmgr-signer, awskms:///alias/stmgr or yubikey:slot=9c. A bare path is treated as softkms:<path>.")
+			signCert := signCmd.String("cert", "", "Certificate corresponding to the private key. Can be omitted if the key manager supplies its own certificate.")
 			signOSPKG := signCmd.String("ospkg", "", "OS package archive or descriptor file. Both need to be present.")
+			signSignerID := signCmd.String("signer-id", "", "Identifies this signature among others in a multi-signature/threshold scheme.")
+			signDetach := signCmd.String("detach", "", "Write a detached signature fragment here instead of appending it to the descriptor, for offline/air-gapped signers.")
+			var signMerge commaSeparated
+			signCmd.Var(&signMerge, "merge", "Comma-separated, or repeated, list of detached signature fragments (see -detach) to append to -ospkg's descriptor instead of signing.")
+			signRekor := signCmd.String("rekor", "", "URL of a Rekor-compatible transparency log to submit the signature to, e.g. https://rekor.sigstore.dev. Left empty, no entry is submitted.")
+			signRekorPubKey := signCmd.String("rekor-public-key", "", "Public key of the transparency log in -rekor, used to verify its response before it is embedded in the descriptor.")
 
 			if err := signCmd.Parse(args[3:]); err != nil {
 				return err
 			}
-			return sign.Run(*signKey, *signCert, *signOSPKG)
+			return sign.Run(sign.Options{
+				Key:            *signKey,
+				Cert:           *signCert,
+				OSPKG:          *signOSPKG,
+				SignerID:       *signSignerID,
+				Detach:         *signDetach,
+				Merge:          signMerge,
+				RekorURL:       *signRekor,
+				RekorPublicKey: *signRekorPubKey,
+			})
+
+		case "verify":
+			// Verify tool and flags
+			verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
+			verifyOSPKG := verifyCmd.String("ospkg", "", "OS package archive or descriptor file. Both need to be present.")
+			verifyRoot := verifyCmd.String("root", "", "Root certificate in PEM format to validate the signer's certificate chain against.")
+			verifyRekorPubKey := verifyCmd.String("rekor-public-key", "", "Public key of the transparency log to verify the package's inclusion proof against.")
+			verifyThreshold := verifyCmd.Int("threshold", 0, "Number of distinct valid signatures required, e.g. 2 for 2-of-3. With -root, only signatures chaining to it count. Defaults to 1.")
+
+			if err := verifyCmd.Parse(args[3:]); err != nil {
+				return err
+			}
+
+			return runOSPKGVerify(*verifyOSPKG, *verifyRoot, *verifyRekorPubKey, *verifyThreshold)
 
 		case "show":
 			// Show tool and flags
-			fmt.Println("Not implemented yet!")
-			return nil
+			showCmd := flag.NewFlagSet("show", flag.ExitOnError)
+			showOSPKG := showCmd.String("ospkg", "", "OS package archive or descriptor file. Both need to be present.")
+			showRoot := showCmd.String("root", "", "Root certificate in PEM format to validate the signer's certificate chain against.")
+			showJSON := showCmd.Bool("json", false, "Emit a JSON report instead of human readable text.")
+
+			if err := showCmd.Parse(args[3:]); err != nil {
+				return err
+			}
+
+			return show.Run(*showOSPKG, *showRoot, *showJSON)
 
 		default:
 			// Display usage on unknown subcommand
@@ -163,11 +286,12 @@ func run(args []string) error {
 			certificateValidUntil := certificateCmd.String("validUntil", "", "Date formatted as RFC822. Defaults to time of creation + 72h.")
 			certificateCertOut := certificateCmd.String("certOut", "", "Output certificate file. Defaults to cert.pem or rootcert.pem is -isCA is set.")
 			certificateKeyOut := certificateCmd.String("keyOut", "", "Output key file. Defaults to key.pem or rootkey.pem if -isCA is set.")
+			certificateKMS := certificateCmd.String("kms", "", "Key URI to generate the key with, e.g. softkms:./ca-key.pem, instead of writing it to -keyOut. Only the softkms backend supports generation today; see kms.Generate.")
 
 			if err := certificateCmd.Parse(args[3:]); err != nil {
 				return err
 			}
-			return keygen.Run(*certificateIsCA, *certificateRootCert, *certificateRootKey, *certificateValidFrom, *certificateValidUntil, *certificateCertOut, *certificateKeyOut)
+			return keygen.Run(*certificateIsCA, *certificateRootCert, *certificateRootKey, *certificateValidFrom, *certificateValidUntil, *certificateCertOut, *certificateKeyOut, *certificateKMS)
 
 		default:
 			// Display usage on unknown subcommand
@@ -175,12 +299,68 @@ func run(args []string) error {
 			return nil
 		}
 
+	case "onboard":
+		// Onboarding tool and flags
+		onboardCmd := flag.NewFlagSet("onboard", flag.ExitOnError)
+		onboardWorkspace := onboardCmd.String("workspace", "", "Directory to write the generated CA, signers, OS package and host configuration into. Defaults to ./stmgr-workspace.")
+		onboardSigners := onboardCmd.Int("signers", 1, "Number of signer certificates to generate.")
+
+		if err := onboardCmd.Parse(args[2:]); err != nil {
+			return err
+		}
+
+		return onboard.Run(onboard.Options{Workspace: *onboardWorkspace, Signers: *onboardSigners})
+
 	case "build":
 		// Check for build subcommands
 		switch args[2] {
+		case "iso":
+			// ISO tool and flags
+			isoCmd := flag.NewFlagSet("buildISO", flag.ExitOnError)
+			isoOSPKG := isoCmd.String("ospkg", "", "OS package descriptor JSON to embed in the image.")
+			isoStboot := isoCmd.String("stboot", "", "stboot kernel.")
+			isoStbootInitramfs := isoCmd.String("stboot-initramfs", "", "stboot initramfs.")
+			isoHostconfig := isoCmd.String("hostconfig", "", "host_configuration.json to embed in the image.")
+			isoOut := isoCmd.String("out", "stboot.iso", "Output ISO path.")
+
+			if err := isoCmd.Parse(args[3:]); err != nil {
+				return err
+			}
+
+			return build.ISO(build.ISOOptions{
+				OSPKG:           *isoOSPKG,
+				Stboot:          *isoStboot,
+				StbootInitramfs: *isoStbootInitramfs,
+				HostConfig:      *isoHostconfig,
+				Out:             *isoOut,
+			}, build.NewRunner())
+
+		case "disk":
+			// Disk tool and flags
+			diskCmd := flag.NewFlagSet("buildDisk", flag.ExitOnError)
+			diskOSPKG := diskCmd.String("ospkg", "", "OS package descriptor JSON to embed in the image.")
+			diskStboot := diskCmd.String("stboot", "", "stboot kernel.")
+			diskStbootInitramfs := diskCmd.String("stboot-initramfs", "", "stboot initramfs.")
+			diskHostconfig := diskCmd.String("hostconfig", "", "host_configuration.json to embed in the image.")
+			diskOut := diskCmd.String("out", "stboot.img", "Output disk image path.")
+			diskSizeMB := diskCmd.Int64("size-mb", 0, "Disk image size in MiB. Defaults to 256.")
+
+			if err := diskCmd.Parse(args[3:]); err != nil {
+				return err
+			}
+
+			return build.Disk(build.DiskOptions{
+				OSPKG:           *diskOSPKG,
+				Stboot:          *diskStboot,
+				StbootInitramfs: *diskStbootInitramfs,
+				HostConfig:      *diskHostconfig,
+				Out:             *diskOut,
+				SizeMB:          *diskSizeMB,
+			}, build.NewRunner())
+
 		default:
 			// Display usage on unknown subcommand
-			fmt.Println("Not implemented yet!")
+			fmt.Print(buildUsage)
 			return nil
 		}
 
@@ -0,0 +1,226 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package verify implements the checks behind "ospkg verify": that an OS
+// package's archive matches its descriptor, that its signature chains to a
+// trusted root, and that it was actually submitted to a transparency log.
+// It is kept separate from ospkg so that stboot can import the verification
+// checks as a library; it imports ospkg itself for descriptor parsing and
+// the signature and certificate checks.
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/system-transparency/stmgr/ospkg"
+	"github.com/system-transparency/stmgr/rekor"
+)
+
+// Result reports the outcome of verifying an OS package, with enough detail
+// for a caller to print a human readable report or map it to an exit code.
+type Result struct {
+	Signed            bool
+	ValidSignatures   int // distinct signers (by certificate) whose signature bytes match the archive
+	TrustedSignatures int // of those, how many also chain to rootCAPath
+	Threshold         int
+	ThresholdMet      bool
+	LoggedOK          bool
+	Descriptor        *ospkg.Descriptor
+}
+
+// Package loads and verifies the OS package at ospkgPath. rootCAPath may be
+// empty, in which case TrustedSignatures is left 0. rekorPublicKeyPath may
+// be empty, in which case the transparency log check is skipped and
+// LoggedOK reports whether a log entry is present at all. threshold is the
+// number of distinct, valid signatures required for ThresholdMet; 0 means
+// "at least one", matching single-signature packages. When rootCAPath is
+// given, only signatures that also chain to it count towards threshold.
+func Package(ospkgPath, rootCAPath, rekorPublicKeyPath string, threshold int) (*Result, error) {
+	archivePath, descriptorPath, err := ospkg.Paths(ospkgPath)
+	if err != nil {
+		return nil, fmt.Errorf("verify: %w", err)
+	}
+
+	d, err := ospkg.LoadDescriptor(descriptorPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Descriptor: d, Threshold: threshold}
+
+	if len(d.Signatures) == 0 {
+		return result, nil
+	}
+
+	result.Signed = true
+
+	digest, err := ospkg.SigningDigest(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveHash, err := ospkg.ArchiveHash(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var anyValidSignature *ospkg.Signature
+
+	seenSigners := make(map[[sha256.Size]byte]bool)
+
+	for i := range d.Signatures {
+		sig := d.Signatures[i]
+
+		cert, err := x509.ParseCertificate(sig.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("verify: parse signer certificate: %w", err)
+		}
+
+		if !ospkg.VerifySignature(cert, digest, sig.Signature) {
+			continue
+		}
+
+		// A signature is only as good as the distinct signer behind it:
+		// two signatures from the same certificate, e.g. from merging a
+		// fragment onto itself, must count once towards the threshold.
+		fingerprint := sha256.Sum256(cert.Raw)
+		if seenSigners[fingerprint] {
+			continue
+		}
+
+		seenSigners[fingerprint] = true
+
+		result.ValidSignatures++
+
+		if anyValidSignature == nil {
+			anyValidSignature = &sig
+		}
+
+		if rootCAPath == "" {
+			continue
+		}
+
+		trusted, err := ospkg.ChainsToRoot(cert, rootCAPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if trusted {
+			result.TrustedSignatures++
+		}
+	}
+
+	want := threshold
+	if want == 0 {
+		want = 1
+	}
+
+	if rootCAPath == "" {
+		result.ThresholdMet = result.ValidSignatures >= want
+	} else {
+		result.ThresholdMet = result.TrustedSignatures >= want
+	}
+
+	if d.Transparency != nil {
+		switch {
+		case rekorPublicKeyPath == "":
+			result.LoggedOK = true
+		case anyValidSignature == nil:
+			result.LoggedOK = false
+		default:
+			ok, err := VerifyInclusion(d.Transparency, archiveHash, anyValidSignature.Signature, anyValidSignature.Certificate, rekorPublicKeyPath)
+			if err != nil {
+				return nil, err
+			}
+
+			result.LoggedOK = ok
+		}
+	}
+
+	return result, nil
+}
+
+// VerifyInclusion recomputes the hashedrekord leaf hash for
+// hash/signature/certDER, walks entry's Merkle audit path to confirm the
+// leaf actually chains to the claimed root hash, and checks that a
+// statement binding index/time/root is signed by the log's public key at
+// rekorPublicKeyPath. This catches both a forged or missing
+// SignedEntryTimestamp and an audit path that doesn't actually lead to the
+// root the log signed off on.
+func VerifyInclusion(entry *rekor.Entry, hash, signature, certDER []byte, rekorPublicKeyPath string) (bool, error) {
+	pub, err := loadEd25519PublicKey(rekorPublicKeyPath)
+	if err != nil {
+		return false, err
+	}
+
+	canonical, err := rekor.CanonicalJSON(hash, signature, certDER)
+	if err != nil {
+		return false, err
+	}
+
+	leaf := rekor.HashLeaf(canonical)
+
+	proof := make([][]byte, len(entry.InclusionProof.Hashes))
+	for i, h := range entry.InclusionProof.Hashes {
+		proof[i] = []byte(h)
+	}
+
+	root, err := rekor.RootFromInclusionProof(entry.InclusionProof.LogIndex, entry.InclusionProof.TreeSize, proof, leaf[:])
+	if err != nil {
+		return false, err
+	}
+
+	if string(root) != entry.InclusionProof.RootHash {
+		return false, nil
+	}
+
+	return ed25519.Verify(pub, logStatement(entry, leaf[:]), entry.SignedEntryTimestamp), nil
+}
+
+// logStatement is the canonical statement a log signs off on for an entry:
+// its index, integration time, the leaf hash and the proof's root hash.
+// VerifyInclusion has already recomputed that root hash from the audit
+// path before this is checked, so a signature over it binds the log to a
+// root it actually committed to for this leaf.
+func logStatement(entry *rekor.Entry, leaf []byte) []byte {
+	buf := make([]byte, 0, 16+len(leaf)+len(entry.InclusionProof.RootHash))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(entry.LogIndex))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(entry.IntegratedTime))
+	buf = append(buf, leaf...)
+	buf = append(buf, entry.InclusionProof.RootHash...)
+
+	sum := sha256.Sum256(buf)
+
+	return sum[:]
+}
+
+func loadEd25519PublicKey(path string) (ed25519.PublicKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("verify: read rekor public key: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("verify: no PEM block found in %s", path)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("verify: parse rekor public key: %w", err)
+	}
+
+	edPub, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("verify: rekor public key in %s is not ED25519", path)
+	}
+
+	return edPub, nil
+}
@@ -0,0 +1,319 @@
+// Copyright 2022 the System Transparency Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/system-transparency/stmgr/ospkg"
+	"github.com/system-transparency/stmgr/rekor"
+)
+
+// ed25519Signer is an ospkg.Signer backed by a real ED25519 private key, so
+// that signatures produced in tests verify the same way a real signer's
+// would.
+type ed25519Signer ed25519.PrivateKey
+
+func (s ed25519Signer) Sign(digest []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(s), digest), nil
+}
+
+func selfSignedCert(t *testing.T, pub ed25519.PublicKey, priv ed25519.PrivateKey) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	return cert
+}
+
+func writeRekorPublicKey(t *testing.T, pub ed25519.PublicKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "rekor.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	if err := os.WriteFile(path, pemBytes, 0o644); err != nil {
+		t.Fatalf("write public key: %v", err)
+	}
+
+	return path
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	hash := []byte("archive-hash")
+	signature := []byte("signature")
+	certDER := []byte("cert")
+
+	canonical, err := rekor.CanonicalJSON(hash, signature, certDER)
+	if err != nil {
+		t.Fatalf("rekor.CanonicalJSON() = %v", err)
+	}
+
+	leaf := rekor.HashLeaf(canonical)
+
+	entry := &rekor.Entry{
+		LogIndex:       1,
+		IntegratedTime: 100,
+	}
+	// A tree holding only this leaf: LogIndex 0, TreeSize 1, no audit path
+	// hashes needed, and the root hash is the leaf hash itself.
+	entry.InclusionProof.LogIndex = 0
+	entry.InclusionProof.TreeSize = 1
+	entry.InclusionProof.RootHash = string(leaf[:])
+
+	entry.SignedEntryTimestamp = ed25519.Sign(priv, logStatement(entry, leaf[:]))
+
+	keyPath := writeRekorPublicKey(t, pub)
+
+	ok, err := VerifyInclusion(entry, hash, signature, certDER, keyPath)
+	if err != nil {
+		t.Fatalf("VerifyInclusion returned error: %v", err)
+	}
+
+	if !ok {
+		t.Fatal("VerifyInclusion() = false, want true for a correctly signed entry")
+	}
+
+	entry.InclusionProof.RootHash = "tampered"
+
+	ok, err = VerifyInclusion(entry, hash, signature, certDER, keyPath)
+	if err != nil {
+		t.Fatalf("VerifyInclusion returned error: %v", err)
+	}
+
+	if ok {
+		t.Fatal("VerifyInclusion() = true, want false after the root hash was tampered with")
+	}
+}
+
+// TestVerifyInclusionRejectsWrongAuditPath guards against VerifyInclusion
+// trusting InclusionProof.RootHash on its own: even if a log statement over
+// a given root hash is validly signed, the leaf must actually recompute to
+// that root via the audit path, or inclusion hasn't been shown.
+func TestVerifyInclusionRejectsWrongAuditPath(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	hash := []byte("archive-hash")
+	signature := []byte("signature")
+	certDER := []byte("cert")
+
+	canonical, err := rekor.CanonicalJSON(hash, signature, certDER)
+	if err != nil {
+		t.Fatalf("rekor.CanonicalJSON() = %v", err)
+	}
+
+	leaf := rekor.HashLeaf(canonical)
+
+	entry := &rekor.Entry{LogIndex: 1, IntegratedTime: 100}
+	// A two-leaf tree, but the "sibling" hash supplied doesn't actually
+	// combine with the leaf to produce RootHash: the log statement below is
+	// validly signed over RootHash, but the audit path doesn't support it.
+	entry.InclusionProof.LogIndex = 0
+	entry.InclusionProof.TreeSize = 2
+	entry.InclusionProof.Hashes = []string{"not-the-real-sibling"}
+	entry.InclusionProof.RootHash = "claimed-root-that-the-path-does-not-produce"
+
+	entry.SignedEntryTimestamp = ed25519.Sign(priv, logStatement(entry, leaf[:]))
+
+	keyPath := writeRekorPublicKey(t, pub)
+
+	ok, err := VerifyInclusion(entry, hash, signature, certDER, keyPath)
+	if err != nil {
+		t.Fatalf("VerifyInclusion returned error: %v", err)
+	}
+
+	if ok {
+		t.Fatal("VerifyInclusion() = true, want false when the audit path doesn't recompute to the claimed root")
+	}
+}
+
+// TestPackageChecksInclusionWithoutRootCA guards against a regression where
+// Package(), given a -rekor-public-key but no -root, skipped the inclusion
+// proof check entirely and reported LoggedOK unconditionally.
+func TestPackageChecksInclusionWithoutRootCA(t *testing.T) {
+	dir := t.TempDir()
+
+	kernel := filepath.Join(dir, "kernel")
+	if err := os.WriteFile(kernel, []byte("kernel-bytes"), 0o644); err != nil {
+		t.Fatalf("write kernel: %v", err)
+	}
+
+	out := filepath.Join(dir, "pkg")
+	if err := ospkg.Run(out, "", "", kernel, "", ""); err != nil {
+		t.Fatalf("ospkg.Run() = %v", err)
+	}
+
+	archivePath, descriptorPath, err := ospkg.Paths(out)
+	if err != nil {
+		t.Fatalf("ospkg.Paths() = %v", err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signer key: %v", err)
+	}
+
+	cert := selfSignedCert(t, pub, priv)
+
+	if err := ospkg.Sign(archivePath, descriptorPath, ed25519Signer(priv), cert, "signer"); err != nil {
+		t.Fatalf("ospkg.Sign() = %v", err)
+	}
+
+	archiveHash, err := ospkg.ArchiveHash(archivePath)
+	if err != nil {
+		t.Fatalf("ospkg.ArchiveHash() = %v", err)
+	}
+
+	d, err := ospkg.LoadDescriptor(descriptorPath)
+	if err != nil {
+		t.Fatalf("ospkg.LoadDescriptor() = %v", err)
+	}
+
+	rekorPub, rekorPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate rekor key: %v", err)
+	}
+
+	sig := d.Signatures[0]
+
+	canonical, err := rekor.CanonicalJSON(archiveHash, sig.Signature, sig.Certificate)
+	if err != nil {
+		t.Fatalf("rekor.CanonicalJSON() = %v", err)
+	}
+
+	leaf := rekor.HashLeaf(canonical)
+
+	entry := &rekor.Entry{LogIndex: 1, IntegratedTime: 100}
+	entry.InclusionProof.LogIndex = 0
+	entry.InclusionProof.TreeSize = 1
+	entry.InclusionProof.RootHash = string(leaf[:])
+
+	entry.SignedEntryTimestamp = ed25519.Sign(rekorPriv, logStatement(entry, leaf[:]))
+
+	// Tamper with the inclusion proof after it was signed, the same way a
+	// forged or stale entry would look.
+	entry.InclusionProof.RootHash = "tampered"
+
+	if err := ospkg.AttachTransparency(descriptorPath, entry); err != nil {
+		t.Fatalf("ospkg.AttachTransparency() = %v", err)
+	}
+
+	rekorKeyPath := writeRekorPublicKey(t, rekorPub)
+
+	result, err := Package(out, "", rekorKeyPath, 0)
+	if err != nil {
+		t.Fatalf("Package() = %v", err)
+	}
+
+	if result.LoggedOK {
+		t.Fatal("Package().LoggedOK = true, want false for a package with a tampered inclusion proof and no -root given")
+	}
+}
+
+// TestPackageDedupesSignaturesByCertificate guards against a single signer
+// being counted twice towards a -threshold, e.g. from a descriptor that
+// (however it was produced) carries the same certificate's signature more
+// than once. ospkg.Sign and ospkg.Merge now refuse to create such a
+// descriptor, but Package must not trust a duplicate that reaches it by
+// some other means either.
+func TestPackageDedupesSignaturesByCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	kernel := filepath.Join(dir, "kernel")
+	if err := os.WriteFile(kernel, []byte("kernel-bytes"), 0o644); err != nil {
+		t.Fatalf("write kernel: %v", err)
+	}
+
+	out := filepath.Join(dir, "pkg")
+	if err := ospkg.Run(out, "", "", kernel, "", ""); err != nil {
+		t.Fatalf("ospkg.Run() = %v", err)
+	}
+
+	archivePath, descriptorPath, err := ospkg.Paths(out)
+	if err != nil {
+		t.Fatalf("ospkg.Paths() = %v", err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signer key: %v", err)
+	}
+
+	cert := selfSignedCert(t, pub, priv)
+
+	if err := ospkg.Sign(archivePath, descriptorPath, ed25519Signer(priv), cert, "release-manager"); err != nil {
+		t.Fatalf("ospkg.Sign() = %v", err)
+	}
+
+	d, err := ospkg.LoadDescriptor(descriptorPath)
+	if err != nil {
+		t.Fatalf("ospkg.LoadDescriptor() = %v", err)
+	}
+
+	// Duplicate the one real signature directly in the descriptor, bypassing
+	// Sign/Merge's own duplicate-certificate check, the way a hand-edited or
+	// pre-existing descriptor could.
+	d.Signatures = append(d.Signatures, d.Signatures[0])
+
+	jsonBytes, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		t.Fatalf("marshal descriptor: %v", err)
+	}
+
+	if err := os.WriteFile(descriptorPath, jsonBytes, 0o644); err != nil {
+		t.Fatalf("write descriptor: %v", err)
+	}
+
+	result, err := Package(out, "", "", 2)
+	if err != nil {
+		t.Fatalf("Package() = %v", err)
+	}
+
+	if result.ValidSignatures != 1 {
+		t.Fatalf("ValidSignatures = %d, want 1 for two signatures from the same certificate", result.ValidSignatures)
+	}
+
+	if result.ThresholdMet {
+		t.Fatal("ThresholdMet = true with -threshold 2, want false for a single signer duplicated in the descriptor")
+	}
+}